@@ -0,0 +1,3 @@
+module github.com/emersion/go-ostatus
+
+go 1.21
@@ -0,0 +1,78 @@
+// Package delivery contains the parts of outgoing delivery queues shared by
+// the salmon and pubsubhubbub packages.
+package delivery
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// A MemoryStore keeps deliveries of type T in memory, indexed by ID. It is
+// safe for concurrent use. Deliveries are copied when stored and listed.
+type MemoryStore[T any] struct {
+	id         func(d *T) string
+	deliveries map[string]*T
+	locker     sync.Mutex
+}
+
+// NewMemoryStore creates a new in-memory store. id returns the ID of a
+// delivery.
+func NewMemoryStore[T any](id func(d *T) string) *MemoryStore[T] {
+	return &MemoryStore[T]{
+		id:         id,
+		deliveries: make(map[string]*T),
+	}
+}
+
+// List returns all deliveries.
+func (s *MemoryStore[T]) List() ([]*T, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	l := make([]*T, 0, len(s.deliveries))
+	for _, d := range s.deliveries {
+		clone := *d
+		l = append(l, &clone)
+	}
+	return l, nil
+}
+
+// Put creates or updates a delivery.
+func (s *MemoryStore[T]) Put(d *T) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	clone := *d
+	s.deliveries[s.id(d)] = &clone
+	return nil
+}
+
+// Delete removes a delivery. It doesn't fail if the delivery doesn't exist.
+func (s *MemoryStore[T]) Delete(id string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	delete(s.deliveries, id)
+	return nil
+}
+
+// IsPermanent checks if retrying a request that failed with the HTTP status
+// code is pointless.
+func IsPermanent(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return code/100 == 4
+}
+
+// Host returns the host of an endpoint, used to limit the number of concurrent
+// requests to a single host. If endpoint isn't a valid URL, it is returned
+// as-is.
+func Host(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil {
+		return u.Host
+	}
+	return endpoint
+}
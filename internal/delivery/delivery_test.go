@@ -0,0 +1,73 @@
+package delivery
+
+import (
+	"net/http"
+	"testing"
+)
+
+type testDelivery struct {
+	ID       string
+	Attempts int
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(func(d *testDelivery) string { return d.ID })
+
+	d := &testDelivery{ID: "a"}
+	if err := s.Put(d); err != nil {
+		t.Fatal("Put() =", err)
+	}
+	// Updating the delivery after Put must not update the store
+	d.Attempts++
+
+	l, err := s.List()
+	if err != nil {
+		t.Fatal("List() =", err)
+	}
+	if len(l) != 1 || l[0].ID != "a" || l[0].Attempts != 0 {
+		t.Fatalf("List() = %+v, want a single delivery without attempts", l)
+	}
+
+	if err := s.Put(d); err != nil {
+		t.Fatal("Put() =", err)
+	}
+	if l, _ := s.List(); len(l) != 1 || l[0].Attempts != 1 {
+		t.Fatalf("List() = %+v, want a single updated delivery", l)
+	}
+
+	if err := s.Delete("a"); err != nil {
+		t.Fatal("Delete() =", err)
+	}
+	if err := s.Delete("a"); err != nil {
+		t.Fatal("Delete(missing delivery) =", err)
+	}
+	if l, _ := s.List(); len(l) != 0 {
+		t.Errorf("List() = %+v, want no delivery", l)
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		code      int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusNotFound, true},
+		{http.StatusRequestTimeout, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	}
+
+	for _, test := range tests {
+		if permanent := IsPermanent(test.code); permanent != test.permanent {
+			t.Errorf("IsPermanent(%v) = %v, want %v", test.code, permanent, test.permanent)
+		}
+	}
+}
+
+func TestHost(t *testing.T) {
+	if host := Host("https://example.com:8443/salmon"); host != "example.com:8443" {
+		t.Errorf("Host() = %v, want example.com:8443", host)
+	}
+}
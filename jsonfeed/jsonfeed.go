@@ -0,0 +1,129 @@
+// Package jsonfeed implements JSON Feed 1.1, as defined in
+// https://www.jsonfeed.org/version/1.1/.
+package jsonfeed
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/emersion/go-ostatus/pubsubhubbub"
+)
+
+// MediaType is the media type of JSON feeds.
+const MediaType = "application/feed+json"
+
+// Version is the URL of the JSON Feed version implemented by this package.
+const Version = "https://jsonfeed.org/version/1.1"
+
+const timeLayout = time.RFC3339
+
+// A Feed is a JSON feed.
+type Feed struct {
+	Version     string    `json:"version"`
+	Title       string    `json:"title"`
+	HomePageURL string    `json:"home_page_url,omitempty"`
+	FeedURL     string    `json:"feed_url,omitempty"`
+	Description string    `json:"description,omitempty"`
+	UserComment string    `json:"user_comment,omitempty"`
+	NextURL     string    `json:"next_url,omitempty"`
+	Icon        string    `json:"icon,omitempty"`
+	Favicon     string    `json:"favicon,omitempty"`
+	Authors     []*Author `json:"authors,omitempty"`
+	Language    string    `json:"language,omitempty"`
+	Expired     bool      `json:"expired,omitempty"`
+	Hubs        []*Hub    `json:"hubs,omitempty"`
+	Items       []*Item   `json:"items"`
+}
+
+func init() {
+	pubsubhubbub.RegisterEventFormat(MediaType, ReadEvent)
+}
+
+// Read parses a feed from r.
+func Read(r io.Reader) (*Feed, error) {
+	feed := new(Feed)
+	err := json.NewDecoder(r).Decode(feed)
+	return feed, err
+}
+
+// ReadEvent is a pubsubhubbub.ReadEventFunc that reads JSON feeds. Importing this
+// package registers it with pubsubhubbub.RegisterEventFormat.
+func ReadEvent(mediaType string, body io.Reader) (pubsubhubbub.Event, error) {
+	feed, err := Read(body)
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// WriteTo writes the feed to w.
+func (feed *Feed) WriteTo(w io.Writer) error {
+	return json.NewEncoder(w).Encode(feed)
+}
+
+// MediaType returns this feed's media type.
+func (feed *Feed) MediaType() string {
+	return MediaType
+}
+
+// Topic returns this feed's topic, which is its feed URL.
+func (feed *Feed) Topic() string {
+	return feed.FeedURL
+}
+
+// An Author is the author of a feed or an item.
+type Author struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+// A Hub is an endpoint that can be used to subscribe to real-time
+// notifications about a feed.
+type Hub struct {
+	// Type is the hub protocol, for instance "WebSub".
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// An Item is a feed item.
+type Item struct {
+	ID            string        `json:"id"`
+	URL           string        `json:"url,omitempty"`
+	ExternalURL   string        `json:"external_url,omitempty"`
+	Title         string        `json:"title,omitempty"`
+	ContentHTML   string        `json:"content_html,omitempty"`
+	ContentText   string        `json:"content_text,omitempty"`
+	Summary       string        `json:"summary,omitempty"`
+	Image         string        `json:"image,omitempty"`
+	BannerImage   string        `json:"banner_image,omitempty"`
+	DatePublished Time          `json:"date_published,omitempty"`
+	DateModified  Time          `json:"date_modified,omitempty"`
+	Authors       []*Author     `json:"authors,omitempty"`
+	Tags          []string      `json:"tags,omitempty"`
+	Language      string        `json:"language,omitempty"`
+	Attachments   []*Attachment `json:"attachments,omitempty"`
+}
+
+// An Attachment is a resource related to an item, such as a podcast episode.
+type Attachment struct {
+	URL               string  `json:"url"`
+	MIMEType          string  `json:"mime_type"`
+	Title             string  `json:"title,omitempty"`
+	SizeInBytes       int64   `json:"size_in_bytes,omitempty"`
+	DurationInSeconds float64 `json:"duration_in_seconds,omitempty"`
+}
+
+// A Time is a formatted time.
+type Time string
+
+// NewTime formats a time.
+func NewTime(t time.Time) Time {
+	return Time(t.Format(timeLayout))
+}
+
+// Time parses a formatted time.
+func (t Time) Time() (time.Time, error) {
+	return time.Parse(timeLayout, string(t))
+}
@@ -0,0 +1,72 @@
+package jsonfeed
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFeed = `{
+	"version": "https://jsonfeed.org/version/1.1",
+	"title": "Example",
+	"home_page_url": "https://example.com/",
+	"feed_url": "https://example.com/feed.json",
+	"hubs": [{"type": "WebSub", "url": "https://hub.example.com/"}],
+	"items": [
+		{
+			"id": "hello",
+			"url": "https://example.com/hello",
+			"content_text": "Hello World!",
+			"date_published": "2017-04-23T10:00:00Z"
+		}
+	]
+}`
+
+func TestRead(t *testing.T) {
+	feed, err := Read(strings.NewReader(testFeed))
+	if err != nil {
+		t.Fatal("Read() =", err)
+	}
+
+	if topic := feed.Topic(); topic != "https://example.com/feed.json" {
+		t.Errorf("Invalid topic: %q", topic)
+	}
+	if len(feed.Hubs) != 1 || feed.Hubs[0].URL != "https://hub.example.com/" {
+		t.Errorf("Invalid hubs: %+v", feed.Hubs)
+	}
+	if len(feed.Items) != 1 {
+		t.Fatalf("Expected one item, got %v", len(feed.Items))
+	}
+	date, err := feed.Items[0].DatePublished.Time()
+	if err != nil || !date.Equal(time.Date(2017, 4, 23, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Invalid item date: %v (%v)", date, err)
+	}
+}
+
+func TestFeed_WriteTo(t *testing.T) {
+	feed := &Feed{
+		Version: Version,
+		Title:   "Example",
+		FeedURL: "https://example.com/feed.json",
+		Items: []*Item{
+			{ID: "hello", ContentText: "Hello World!"},
+		},
+	}
+
+	var b bytes.Buffer
+	if err := feed.WriteTo(&b); err != nil {
+		t.Fatal("WriteTo() =", err)
+	}
+	if strings.Contains(b.String(), "date_published") {
+		t.Errorf("Empty fields not omitted: %v", b.String())
+	}
+
+	feed, err := Read(&b)
+	if err != nil {
+		t.Fatal("Read() =", err)
+	}
+	if feed.Topic() != "https://example.com/feed.json" || len(feed.Items) != 1 {
+		t.Errorf("Invalid feed after round-trip: %+v", feed)
+	}
+}
@@ -0,0 +1,206 @@
+package pubsubhubbub
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// CallbackInfo describes a callback subscribed to a topic.
+type CallbackInfo struct {
+	Callback string    `json:"callback"`
+	LeaseEnd time.Time `json:"lease_end"`
+	// LastDelivery is the time of the last delivery attempt. It's zero if no
+	// notification has been sent to the callback yet.
+	LastDelivery time.Time `json:"last_delivery"`
+	// LastError is the error of the last delivery attempt, if it failed.
+	LastError string `json:"last_error,omitempty"`
+}
+
+var (
+	errNoSuchSubscription = errors.New("pubsubhubbub: no such subscription")
+	errNoEvent            = errors.New("pubsubhubbub: no event to redeliver")
+)
+
+func (p *Publisher) subscription(topicURL string) (*pubSubscription, bool) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	s, ok := p.subscriptions[topicURL]
+	return s, ok
+}
+
+// Topics returns the list of topics with at least one subscriber.
+func (p *Publisher) Topics() []string {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	topics := make([]string, 0, len(p.subscriptions))
+	for topicURL := range p.subscriptions {
+		topics = append(topics, topicURL)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Callbacks returns the callbacks subscribed to a topic.
+func (p *Publisher) Callbacks(topicURL string) []CallbackInfo {
+	s, ok := p.subscription(topicURL)
+	if !ok {
+		return nil
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	l := make([]CallbackInfo, 0, len(s.callbacks))
+	for callbackURL, cb := range s.callbacks {
+		info := CallbackInfo{
+			Callback:     callbackURL,
+			LeaseEnd:     cb.leaseEnd,
+			LastDelivery: cb.lastDelivery,
+		}
+		if cb.lastErr != nil {
+			info.LastError = cb.lastErr.Error()
+		}
+		l = append(l, info)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Callback < l[j].Callback
+	})
+	return l
+}
+
+// ForceUnsubscribe removes a callback's subscription to a topic, without
+// asking the subscriber.
+func (p *Publisher) ForceUnsubscribe(topicURL, callbackURL string) error {
+	s, ok := p.subscription(topicURL)
+	if ok {
+		s.locker.Lock()
+		_, ok = s.callbacks[callbackURL]
+		s.locker.Unlock()
+	}
+	if !ok {
+		return errNoSuchSubscription
+	}
+
+	return p.unregister(topicURL, callbackURL)
+}
+
+// Redeliver sends the last event about a topic again. If callbackURL is empty,
+// the event is sent to all subscribers.
+func (p *Publisher) Redeliver(topicURL, callbackURL string) error {
+	s, ok := p.subscription(topicURL)
+	if !ok {
+		return errNoSuchSubscription
+	}
+
+	s.locker.Lock()
+	mediaType, body := s.lastMediaType, s.lastBody
+	var callbacks []string
+	if callbackURL == "" {
+		for callbackURL := range s.callbacks {
+			callbacks = append(callbacks, callbackURL)
+		}
+	} else if _, ok := s.callbacks[callbackURL]; ok {
+		callbacks = []string{callbackURL}
+	}
+	s.locker.Unlock()
+
+	if len(callbacks) == 0 {
+		return errNoSuchSubscription
+	}
+	if body == nil {
+		return errNoEvent
+	}
+
+	now := p.Clock.Now()
+	for _, callbackURL := range callbacks {
+		id, err := randomString(16)
+		if err != nil {
+			return err
+		}
+
+		p.enqueue(&Delivery{
+			ID:          id,
+			Topic:       topicURL,
+			Callback:    callbackURL,
+			MediaType:   mediaType,
+			Body:        body,
+			Created:     now,
+			NextAttempt: now,
+		})
+	}
+	return nil
+}
+
+type adminTopic struct {
+	Topic     string         `json:"topic"`
+	Callbacks []CallbackInfo `json:"callbacks"`
+}
+
+type adminHandler struct {
+	p *Publisher
+}
+
+// AdminHandler returns an HTTP handler exposing the publisher's state as JSON.
+//
+// GET requests list topics and their callbacks, optionally filtered with the
+// "topic" query parameter. POST requests perform the operation specified by
+// the "action" form value: "unsubscribe" calls ForceUnsubscribe and
+// "redeliver" calls Redeliver, with the "topic" and "callback" form values.
+//
+// The handler doesn't perform any authentication.
+func (p *Publisher) AdminHandler() http.Handler {
+	return &adminHandler{p}
+}
+
+func (h *adminHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		var topics []string
+		if topicURL := req.URL.Query().Get("topic"); topicURL != "" {
+			topics = []string{topicURL}
+		} else {
+			topics = h.p.Topics()
+		}
+
+		l := make([]adminTopic, 0, len(topics))
+		for _, topicURL := range topics {
+			callbacks := h.p.Callbacks(topicURL)
+			if callbacks == nil {
+				continue
+			}
+			l = append(l, adminTopic{topicURL, callbacks})
+		}
+
+		resp.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(resp).Encode(l)
+	case http.MethodPost:
+		topicURL := req.FormValue("topic")
+		callbackURL := req.FormValue("callback")
+
+		var err error
+		switch req.FormValue("action") {
+		case "unsubscribe":
+			err = h.p.ForceUnsubscribe(topicURL, callbackURL)
+		case "redeliver":
+			err = h.p.Redeliver(topicURL, callbackURL)
+		default:
+			http.Error(resp, "Invalid action", http.StatusBadRequest)
+			return
+		}
+
+		if err == errNoSuchSubscription || err == errNoEvent {
+			http.Error(resp, err.Error(), http.StatusNotFound)
+		} else if err != nil {
+			http.Error(resp, err.Error(), http.StatusInternalServerError)
+		} else {
+			resp.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.Error(resp, "Unsupported method", http.StatusMethodNotAllowed)
+	}
+}
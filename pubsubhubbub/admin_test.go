@@ -0,0 +1,122 @@
+package pubsubhubbub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminAction(h http.Handler, action, topicURL, callbackURL string) int {
+	data := url.Values{"action": {action}, "topic": {topicURL}, "callback": {callbackURL}}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestPublisher_AdminHandler(t *testing.T) {
+	topicURL := "http://localhost/publisher/topic.atom"
+	okCallback := "http://localhost/subscriber/ok"
+	failingCallback := "http://localhost/subscriber/failing"
+
+	received := make(chan string, 10)
+	pub, be := testPublisher(t, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/subscriber/failing" {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+		resp.WriteHeader(http.StatusNoContent)
+		received <- req.URL.String()
+	}))
+	failed := make(chan struct{}, 1)
+	pub.DeliveryFailed = func(topicURL, callbackURL string, err error) {
+		failed <- struct{}{}
+	}
+	h := pub.AdminHandler()
+
+	if code := adminAction(h, "redeliver", topicURL, ""); code != http.StatusNotFound {
+		t.Errorf("Expected status %v when redelivering an unknown topic, got %v", http.StatusNotFound, code)
+	}
+
+	leaseEnd := time.Now().Add(time.Hour).Truncate(time.Second)
+	for _, callbackURL := range []string{okCallback, failingCallback} {
+		if err := pub.Register(topicURL, callbackURL, "", leaseEnd); err != nil {
+			t.Fatal("Register() =", err)
+		}
+	}
+
+	if code := adminAction(h, "redeliver", topicURL, ""); code != http.StatusNotFound {
+		t.Errorf("Expected status %v when redelivering without event, got %v", http.StatusNotFound, code)
+	}
+
+	be.notifies(topicURL) <- testFeed(topicURL)
+	<-received
+	<-failed
+
+	// Wait for the delivery status to be recorded
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		pending := false
+		for _, cb := range pub.Callbacks(topicURL) {
+			pending = pending || cb.LastDelivery.IsZero()
+		}
+		if !pending {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var topics []adminTopic
+	if err := json.NewDecoder(w.Body).Decode(&topics); err != nil {
+		t.Fatal("Invalid admin response:", err)
+	}
+	if len(topics) != 1 || topics[0].Topic != topicURL || len(topics[0].Callbacks) != 2 {
+		t.Fatalf("Invalid topics: %+v", topics)
+	}
+	for _, cb := range topics[0].Callbacks {
+		if !cb.LeaseEnd.Equal(leaseEnd) {
+			t.Errorf("%v: expected lease end %v, got %v", cb.Callback, leaseEnd, cb.LeaseEnd)
+		}
+		if cb.LastDelivery.IsZero() {
+			t.Errorf("%v: no last delivery", cb.Callback)
+		}
+		if failing := cb.Callback == failingCallback; failing != (cb.LastError != "") {
+			t.Errorf("%v: invalid last error %q", cb.Callback, cb.LastError)
+		}
+	}
+
+	if code := adminAction(h, "redeliver", topicURL, okCallback); code != http.StatusNoContent {
+		t.Fatalf("Redeliver failed: %v", code)
+	}
+	select {
+	case callbackURL := <-received:
+		if callbackURL != okCallback {
+			t.Errorf("Event redelivered to %v instead of %v", callbackURL, okCallback)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Event not redelivered")
+	}
+
+	if code := adminAction(h, "unsubscribe", topicURL, failingCallback); code != http.StatusNoContent {
+		t.Fatalf("ForceUnsubscribe failed: %v", code)
+	}
+	if code := adminAction(h, "unsubscribe", topicURL, failingCallback); code != http.StatusNotFound {
+		t.Errorf("Expected status %v when unsubscribing twice, got %v", http.StatusNotFound, code)
+	}
+	if l := pub.Callbacks(topicURL); len(l) != 1 || l[0].Callback != okCallback {
+		t.Errorf("Invalid callbacks after unsubscribing: %+v", l)
+	}
+
+	if code := adminAction(h, "unsubscribe", topicURL, okCallback); code != http.StatusNoContent {
+		t.Fatalf("ForceUnsubscribe failed: %v", code)
+	}
+	if l := pub.Topics(); len(l) != 0 {
+		t.Errorf("Expected no topic, got %v", l)
+	}
+}
@@ -0,0 +1,51 @@
+package pubsubhubbub
+
+import (
+	"net/url"
+	"strings"
+)
+
+const (
+	// callbackIDTemplate is replaced with the subscription identifier in
+	// callback URLs.
+	callbackIDTemplate = "{id}"
+	// callbackIDParam is the query parameter carrying the subscription
+	// identifier, if the callback URL has no template.
+	callbackIDParam = "id"
+)
+
+// subscriptionCallback returns the callback URL of a subscription.
+func (s *Subscriber) subscriptionCallback(id string) (string, error) {
+	if strings.Contains(s.callbackURL, callbackIDTemplate) {
+		return strings.Replace(s.callbackURL, callbackIDTemplate, id, 1), nil
+	}
+
+	u, err := url.Parse(s.callbackURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(callbackIDParam, id)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// callbackID returns the subscription identifier of a callback URL.
+func (s *Subscriber) callbackID(u *url.URL) string {
+	i := strings.Index(s.callbackURL, callbackIDTemplate)
+	if i < 0 {
+		return u.Query().Get(callbackIDParam)
+	}
+
+	// Only the part of the path following the identifier is compared, the
+	// handler may be mounted anywhere
+	suffix := s.callbackURL[i+len(callbackIDTemplate):]
+	if j := strings.IndexAny(suffix, "?#"); j >= 0 {
+		suffix = suffix[:j]
+	}
+	if !strings.HasSuffix(u.Path, suffix) {
+		return ""
+	}
+	p := strings.TrimSuffix(u.Path, suffix)
+	return p[strings.LastIndex(p, "/")+1:]
+}
@@ -0,0 +1,88 @@
+package pubsubhubbub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscriber_callbackID(t *testing.T) {
+	tests := []struct {
+		callbackURL string
+		url         string
+		id          string
+	}{
+		{"http://localhost/webhook", "http://localhost/webhook?id=abc", "abc"},
+		{"http://localhost/webhook?a=b", "http://localhost/webhook?a=b&id=abc", "abc"},
+		{"http://localhost/webhook", "http://localhost/webhook", ""},
+		{"http://localhost/webhook/{id}", "http://localhost/webhook/abc", "abc"},
+		{"http://localhost/webhook/{id}", "/abc", "abc"},
+		{"http://localhost/webhook/{id}/push?a=b", "http://localhost/webhook/abc/push?a=b", "abc"},
+		{"http://localhost/webhook/{id}/push", "http://localhost/webhook/abc", ""},
+	}
+
+	for _, test := range tests {
+		s := NewSubscriber(test.callbackURL, readAtomEvent)
+		u, _ := url.Parse(test.url)
+		if id := s.callbackID(u); id != test.id {
+			t.Errorf("callbackID(%q, %q) = %q, want %q", test.callbackURL, test.url, id, test.id)
+		}
+	}
+}
+
+func TestSubscriber_callbackURL(t *testing.T) {
+	hubURL := "http://localhost/hub"
+
+	be := newDummyBackend()
+	pub := NewPublisher(be)
+	sub := NewSubscriber("http://localhost/subscriber/{id}", readAtomEvent)
+	pub.c.Transport = &roundTripper{sub}
+	sub.c.Transport = &roundTripper{pub}
+
+	registered := make(chan string, 2)
+	pub.SubscriptionState = func(topicURL, callbackURL, secret string, leaseEnd time.Time) {
+		registered <- callbackURL
+	}
+
+	var callbacks []string
+	for _, topicURL := range []string{"http://localhost/topic1.atom", "http://localhost/topic2.atom"} {
+		if err := sub.Subscribe(hubURL, topicURL, make(chan Event, 1)); err != nil {
+			t.Fatalf("Subscribe(%v) = %v", topicURL, err)
+		}
+
+		callbackURL := <-registered
+		if !strings.HasPrefix(callbackURL, "http://localhost/subscriber/") {
+			t.Errorf("Invalid callback URL: %v", callbackURL)
+		}
+		if strings.Contains(callbackURL, "topic") {
+			t.Errorf("Callback URL %v exposes the topic", callbackURL)
+		}
+		callbacks = append(callbacks, callbackURL)
+	}
+
+	if callbacks[0] == callbacks[1] {
+		t.Errorf("Expected different callbacks, got %v twice", callbacks[0])
+	}
+
+	// Verification requests must be about the subscription's topic
+	q := url.Values{
+		"hub.mode":      {"unsubscribe"},
+		"hub.topic":     {"http://localhost/topic2.atom"},
+		"hub.challenge": {"challenge"},
+	}
+	w := httptest.NewRecorder()
+	sub.ServeHTTP(w, httptest.NewRequest(http.MethodGet, callbacks[0]+"?"+q.Encode(), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %v for a verification about another topic, got %v", http.StatusNotFound, w.Code)
+	}
+
+	// Notifications must be sent to a known callback
+	w = postNotification(context.Background(), sub, "http://localhost/subscriber/unknown", "", testFeed("http://localhost/topic1.atom"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %v for an unknown callback, got %v", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,34 @@
+package pubsubhubbub
+
+import (
+	"time"
+)
+
+// A Clock tells the time and runs functions after a delay. It can be replaced
+// to control time in tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A Timer is a function call scheduled by a Clock.
+type Timer interface {
+	// Stop prevents the function from being called. It returns false if the
+	// function has already been called or the timer has already been stopped.
+	Stop() bool
+}
+
+type systemClock struct{}
+
+// SystemClock is the Clock using the system time.
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
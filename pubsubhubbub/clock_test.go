@@ -0,0 +1,230 @@
+package pubsubhubbub
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only changes when Advance is called.
+type fakeClock struct {
+	locker sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	when    time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	t := &fakeTimer{clock: c, when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.locker.Lock()
+	defer t.clock.locker.Unlock()
+
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+// Advance moves the time forward, and synchronously calls the functions of
+// the timers that expire.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.locker.Lock()
+	c.now = c.now.Add(d)
+
+	var expired []*fakeTimer
+	for _, t := range c.timers {
+		if !t.stopped && !t.when.After(c.now) {
+			t.stopped = true
+			expired = append(expired, t)
+		}
+	}
+	c.locker.Unlock()
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].when.Before(expired[j].when)
+	})
+	for _, t := range expired {
+		t.f()
+	}
+}
+
+func isRegistered(p *Publisher, topicURL, callbackURL string) bool {
+	p.locker.Lock()
+	s, ok := p.subscriptions[topicURL]
+	p.locker.Unlock()
+	if !ok {
+		return false
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	_, ok = s.callbacks[callbackURL]
+	return ok
+}
+
+func TestPublisher_leaseExpiry(t *testing.T) {
+	topicURL := "http://localhost/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook"
+
+	clock := newFakeClock()
+	p := NewPublisher(newDummyBackend())
+	p.Clock = clock
+
+	if err := p.Register(topicURL, callbackURL, "", clock.Now().Add(time.Hour)); err != nil {
+		t.Fatal("Register() =", err)
+	}
+
+	clock.Advance(59 * time.Minute)
+	if !isRegistered(p, topicURL, callbackURL) {
+		t.Fatal("Subscription expired before the end of its lease")
+	}
+
+	clock.Advance(time.Minute)
+	if isRegistered(p, topicURL, callbackURL) {
+		t.Fatal("Subscription not expired at the end of its lease")
+	}
+	if l, _ := p.store.List(); len(l) != 0 {
+		t.Errorf("Expected expired subscription to be removed from the store, got %v", l)
+	}
+
+	// Unregistering an expired subscription doesn't block
+	if err := p.unregister(topicURL, callbackURL); err != nil {
+		t.Error("unregister() =", err)
+	}
+}
+
+func TestNewPublisherWithStore_clock(t *testing.T) {
+	topicURL := "http://localhost/topic.atom"
+	expiredTopicURL := "http://localhost/expired.atom"
+	callbackURL := "http://localhost/subscriber/webhook"
+
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	store.Put(&Subscription{
+		Topic:    topicURL,
+		Callback: callbackURL,
+		LeaseEnd: clock.Now().Add(time.Hour),
+	})
+	store.Put(&Subscription{
+		Topic:    expiredTopicURL,
+		Callback: callbackURL,
+		LeaseEnd: clock.Now().Add(-time.Hour),
+	})
+
+	p, err := NewPublisherWithStore(newDummyBackend(), store, clock)
+	if err != nil {
+		t.Fatal("NewPublisherWithStore() =", err)
+	}
+	if !isRegistered(p, topicURL, callbackURL) {
+		t.Fatal("Subscription not restored")
+	}
+	if isRegistered(p, expiredTopicURL, callbackURL) {
+		t.Fatal("Expired subscription restored")
+	}
+
+	clock.Advance(time.Hour)
+	if isRegistered(p, topicURL, callbackURL) {
+		t.Fatal("Restored subscription not expired at the end of its lease")
+	}
+}
+
+func TestPublisher_leaseRenewal(t *testing.T) {
+	topicURL := "http://localhost/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook?id=test"
+
+	clock := newFakeClock()
+	p := NewPublisher(newDummyBackend())
+	p.Clock = clock
+	// This subscriber accepts all verification requests
+	p.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte(req.URL.Query().Get("hub.challenge")))
+	})}
+
+	if err := p.Subscribe(topicURL, callbackURL, "", time.Hour); err != nil {
+		t.Fatal("Subscribe() =", err)
+	}
+
+	clock.Advance(30 * time.Minute)
+	if err := p.Subscribe(topicURL, callbackURL, "", time.Hour); err != nil {
+		t.Fatal("Subscribe() for renewal =", err)
+	}
+
+	// The first lease has ended, but not the renewed one
+	clock.Advance(45 * time.Minute)
+	if !isRegistered(p, topicURL, callbackURL) {
+		t.Fatal("Renewed subscription expired with its previous lease")
+	}
+
+	clock.Advance(15 * time.Minute)
+	if isRegistered(p, topicURL, callbackURL) {
+		t.Fatal("Renewed subscription not expired at the end of its lease")
+	}
+}
+
+func TestPublisher_leaseBounds(t *testing.T) {
+	topicURL := "http://localhost/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook?id=test"
+
+	p := NewPublisher(newDummyBackend())
+	p.Clock = newFakeClock()
+	p.MinLease = time.Hour
+	p.MaxLease = 24 * time.Hour
+
+	var granted string
+	p.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		granted = q.Get("hub.lease_seconds")
+		resp.Write([]byte(q.Get("hub.challenge")))
+	})}
+
+	tests := []struct {
+		requested, granted time.Duration
+	}{
+		{time.Minute, time.Hour},
+		{2 * time.Hour, 2 * time.Hour},
+		{365 * 24 * time.Hour, 24 * time.Hour},
+		{0, DefaultLease},
+	}
+
+	for _, test := range tests {
+		if err := p.Subscribe(topicURL, callbackURL, "", test.requested); err != nil {
+			t.Fatalf("Subscribe(%v) = %v", test.requested, err)
+		}
+
+		want := strconv.Itoa(int(test.granted.Seconds()))
+		if granted != want {
+			t.Errorf("Requested %v: expected lease_seconds %v, got %v", test.requested, want, granted)
+		}
+
+		l, _ := p.store.List()
+		if len(l) != 1 || !l[0].LeaseEnd.Equal(p.Clock.Now().Add(test.granted)) {
+			t.Errorf("Requested %v: invalid stored subscription: %+v", test.requested, l)
+		}
+	}
+}
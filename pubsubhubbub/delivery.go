@@ -0,0 +1,404 @@
+package pubsubhubbub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/emersion/go-ostatus/internal/delivery"
+)
+
+// Default Publisher delivery parameters.
+var (
+	// DefaultDeliveryRetryDelay is the default delay before retrying a failed
+	// delivery.
+	DefaultDeliveryRetryDelay = 30 * time.Second
+	// DefaultMaxDeliveryAge is the default maximum age of a delivery.
+	DefaultMaxDeliveryAge = 24 * time.Hour
+	// DefaultWorkers is the default number of concurrent deliveries.
+	DefaultWorkers = 16
+	// DefaultMaxPerHost is the default maximum number of concurrent deliveries
+	// to a single host.
+	DefaultMaxPerHost = 4
+	// DefaultMaxQueuedPerHost is the default maximum number of deliveries
+	// waiting for a single host.
+	DefaultMaxQueuedPerHost = 64
+	// DefaultDeliveryTimeout is the default timeout of a delivery request.
+	DefaultDeliveryTimeout = 30 * time.Second
+)
+
+// errDeliveryExpired is passed to Publisher.DeliveryFailed when a delivery
+// cannot be retried anymore.
+var errDeliveryExpired = errors.New("pubsubhubbub: delivery expired")
+
+// A Delivery is a content distribution request to a callback.
+type Delivery struct {
+	ID          string    `json:"id"`
+	Topic       string    `json:"topic"`
+	Callback    string    `json:"callback"`
+	MediaType   string    `json:"media_type"`
+	Body        []byte    `json:"body"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// A DeliveryStore persists the deliveries a Publisher has to retry.
+type DeliveryStore interface {
+	// List returns all pending deliveries.
+	List() ([]*Delivery, error)
+	// Put creates or updates a delivery.
+	Put(d *Delivery) error
+	// Delete removes a delivery. It doesn't fail if the delivery doesn't
+	// exist.
+	Delete(id string) error
+}
+
+// NewMemoryDeliveryStore returns a DeliveryStore that keeps deliveries in
+// memory.
+func NewMemoryDeliveryStore() DeliveryStore {
+	return delivery.NewMemoryStore(func(d *Delivery) string {
+		return d.ID
+	})
+}
+
+// ResumeDeliveries schedules the deliveries left in the publisher's
+// DeliveryStore by a previous run. Deliveries that are already scheduled or in
+// flight are left untouched.
+func (p *Publisher) ResumeDeliveries() error {
+	l, err := p.Deliveries.List()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range l {
+		p.scheduleDelivery(d)
+	}
+	return nil
+}
+
+// enqueue hands a delivery to a worker. It blocks until a worker is available.
+func (p *Publisher) enqueue(d *Delivery) {
+	p.startWorkers.Do(func() {
+		n := p.Workers
+		if n <= 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			if !p.acquire() {
+				break
+			}
+			go func() {
+				defer p.wg.Done()
+				for {
+					select {
+					case d := <-p.jobs:
+						p.dispatch(d)
+					case <-p.done:
+						return
+					}
+				}
+			}()
+		}
+	})
+
+	select {
+	case p.jobs <- d:
+	case <-p.done:
+		// The publisher is shutting down, keep the delivery for the next run
+		p.keepDelivery(d)
+	}
+}
+
+// keepDelivery saves a delivery that couldn't be attempted so that it can be
+// resumed by the next run.
+func (p *Publisher) keepDelivery(d *Delivery) {
+	if err := p.Deliveries.Put(d); err != nil {
+		p.logger().Error("cannot save delivery", "topic", d.Topic, "callback", d.Callback, "err", err)
+	}
+}
+
+// A hostQueue tracks the deliveries to a single host.
+type hostQueue struct {
+	// active is the number of workers delivering to the host.
+	active int
+	// pending holds deliveries waiting for one of these workers.
+	pending []*Delivery
+	// room is closed when a pending delivery is dequeued. It's nil if no
+	// worker is waiting for the queue to have room.
+	room chan struct{}
+}
+
+// dispatch delivers d. If MaxPerHost workers are already delivering to the
+// callback's host, d is queued instead and the worker is released: it will be
+// delivered by one of these workers. This way, a slow host cannot keep more
+// than MaxPerHost workers busy. If the queue is full, the worker waits for it
+// to have room, so that the Backend eventually stops being read.
+func (p *Publisher) dispatch(d *Delivery) {
+	host := delivery.Host(d.Callback)
+
+	var q *hostQueue
+	for {
+		p.locker.Lock()
+		var ok bool
+		q, ok = p.hosts[host]
+		if !ok {
+			q = new(hostQueue)
+			p.hosts[host] = q
+		}
+		if p.MaxPerHost <= 0 || q.active < p.MaxPerHost {
+			q.active++
+			p.locker.Unlock()
+			break
+		}
+		if p.MaxQueuedPerHost <= 0 || len(q.pending) < p.MaxQueuedPerHost {
+			q.pending = append(q.pending, d)
+			p.locker.Unlock()
+			return
+		}
+		if q.room == nil {
+			q.room = make(chan struct{})
+		}
+		room := q.room
+		p.locker.Unlock()
+
+		select {
+		case <-room:
+		case <-p.done:
+			p.keepDelivery(d)
+			return
+		}
+	}
+
+	for d != nil {
+		p.deliver(d)
+
+		p.locker.Lock()
+		d = nil
+		if len(q.pending) > 0 {
+			d = q.pending[0]
+			q.pending = q.pending[1:]
+			if q.room != nil {
+				close(q.room)
+				q.room = nil
+			}
+		} else {
+			q.active--
+			if q.active == 0 {
+				delete(p.hosts, host)
+			}
+		}
+		p.locker.Unlock()
+	}
+}
+
+// setDeliveryStatus records the result of the last delivery to a callback.
+func (p *Publisher) setDeliveryStatus(topicURL, callbackURL string, err error) {
+	p.locker.Lock()
+	s, ok := p.subscriptions[topicURL]
+	p.locker.Unlock()
+	if !ok {
+		return
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if cb, ok := s.callbacks[callbackURL]; ok {
+		cb.lastDelivery = p.Clock.Now()
+		cb.lastErr = err
+	}
+}
+
+// callbackSecret returns the secret of a callback, and false if the callback
+// isn't subscribed to the topic anymore.
+func (p *Publisher) callbackSecret(topicURL, callbackURL string) (string, bool) {
+	p.locker.Lock()
+	s, ok := p.subscriptions[topicURL]
+	p.locker.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	cb, ok := s.callbacks[callbackURL]
+	if !ok {
+		return "", false
+	}
+	return cb.secret, true
+}
+
+func (p *Publisher) push(d *Delivery, secret string) error {
+	ctx := p.ctx
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Callback, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", d.MediaType)
+	if p.WebSub {
+		if hubURL := p.hubURL(); hubURL != "" {
+			req.Header.Add("Link", "<"+hubURL+`>; rel="hub"`)
+		} else {
+			p.logger().Warn("hub URL unknown, cannot advertise it", "topic", d.Topic, "callback", d.Callback)
+		}
+		req.Header.Add("Link", "<"+d.Topic+`>; rel="self"`)
+	}
+
+	if secret != "" {
+		sig, err := sign(p.SignatureAlgorithm, secret, d.Body)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Hub-Signature", sig)
+	}
+
+	resp, err := p.c.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return HTTPError(resp.StatusCode)
+	}
+	return nil
+}
+
+// deliver sends a delivery, and schedules a retry if it fails.
+func (p *Publisher) deliver(d *Delivery) {
+	p.locker.Lock()
+	p.delivering[d.ID] = true
+	p.locker.Unlock()
+
+	retry := p.attempt(d)
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	delete(p.delivering, d.ID)
+	if retry {
+		p.scheduleDeliveryLocked(d)
+	}
+}
+
+// attempt sends a delivery. It returns true if the delivery has to be retried.
+func (p *Publisher) attempt(d *Delivery) bool {
+	secret, ok := p.callbackSecret(d.Topic, d.Callback)
+	if !ok {
+		// The callback has unsubscribed in the meantime
+		p.Deliveries.Delete(d.ID)
+		return false
+	}
+
+	err := p.push(d, secret)
+	if err == nil {
+		p.setDeliveryStatus(d.Topic, d.Callback, nil)
+		// The delivery may have been saved by a previous run
+		p.Deliveries.Delete(d.ID)
+		return false
+	}
+
+	if p.ctx.Err() != nil {
+		// Canceled by Shutdown, keep the delivery for the next run
+		p.keepDelivery(d)
+		return false
+	}
+	p.setDeliveryStatus(d.Topic, d.Callback, err)
+
+	p.logger().Warn("cannot push notification", "topic", d.Topic, "callback", d.Callback, "attempt", d.Attempts+1, "err", err)
+
+	if p.WebSub && err == HTTPError(http.StatusGone) {
+		// The subscriber doesn't want notifications anymore
+		p.Deliveries.Delete(d.ID)
+		if err := p.unregister(d.Topic, d.Callback); err != nil {
+			p.logger().Error("cannot unsubscribe gone callback", "topic", d.Topic, "callback", d.Callback, "err", err)
+		}
+		return false
+	}
+
+	d.Attempts++
+	delay := p.deliveryRetryDelay(d.Attempts)
+	now := p.Clock.Now()
+	if isPermanent(err) || now.Add(delay).Sub(d.Created) > p.MaxDeliveryAge {
+		p.Deliveries.Delete(d.ID)
+		if !isPermanent(err) {
+			err = errDeliveryExpired
+		}
+		if p.DeliveryFailed != nil {
+			p.DeliveryFailed(d.Topic, d.Callback, err)
+		}
+		return false
+	}
+
+	d.NextAttempt = now.Add(delay)
+	if err := p.Deliveries.Put(d); err != nil {
+		p.logger().Error("cannot save delivery", "topic", d.Topic, "callback", d.Callback, "err", err)
+	}
+	return true
+}
+
+// scheduleDelivery schedules the next attempt of a delivery. It's a no-op if
+// the delivery is already scheduled or in flight.
+func (p *Publisher) scheduleDelivery(d *Delivery) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.scheduleDeliveryLocked(d)
+}
+
+// scheduleDeliveryLocked is like scheduleDelivery, but must be called with
+// p.locker held.
+func (p *Publisher) scheduleDeliveryLocked(d *Delivery) {
+	if p.closed {
+		// The delivery is in the store, it'll be resumed on the next run
+		return
+	}
+	if _, ok := p.retries[d.ID]; ok || p.delivering[d.ID] {
+		return
+	}
+
+	p.retries[d.ID] = p.Clock.AfterFunc(d.NextAttempt.Sub(p.Clock.Now()), func() {
+		p.locker.Lock()
+		delete(p.retries, d.ID)
+		// The delivery is in flight until deliver is done with it
+		p.delivering[d.ID] = true
+		p.locker.Unlock()
+
+		p.enqueue(d)
+	})
+}
+
+// deliveryRetryDelay returns the delay before the next attempt, after n
+// attempts.
+func (p *Publisher) deliveryRetryDelay(n int) time.Duration {
+	d := p.RetryDelay
+	if d <= 0 {
+		// Never retry in a busy loop
+		d = DefaultDeliveryRetryDelay
+	}
+	for i := 1; i < n && d < p.MaxDeliveryAge; i++ {
+		d *= 2
+	}
+	return d
+}
+
+// isPermanent checks if retrying a failed delivery is pointless.
+func isPermanent(err error) bool {
+	if err == errUnsupportedSignature {
+		return true
+	}
+
+	code, ok := err.(HTTPError)
+	return ok && delivery.IsPermanent(int(code))
+}
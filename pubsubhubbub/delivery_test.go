@@ -0,0 +1,507 @@
+package pubsubhubbub
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCallback is a callback that fails the first requests.
+type testCallback struct {
+	fails    int
+	status   int
+	locker   sync.Mutex
+	requests int
+	received chan struct{}
+}
+
+func newTestCallback(fails, status int) *testCallback {
+	return &testCallback{
+		fails:    fails,
+		status:   status,
+		received: make(chan struct{}, 16),
+	}
+}
+
+func (cb *testCallback) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	cb.locker.Lock()
+	cb.requests++
+	n := cb.requests
+	cb.locker.Unlock()
+
+	if n <= cb.fails {
+		resp.WriteHeader(cb.status)
+		return
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+	cb.received <- struct{}{}
+}
+
+func (cb *testCallback) numRequests() int {
+	cb.locker.Lock()
+	defer cb.locker.Unlock()
+	return cb.requests
+}
+
+func testPublisher(t *testing.T, cb http.Handler) (*Publisher, *dummyBackend) {
+	be := newDummyBackend()
+	pub := NewPublisher(be)
+	pub.RetryDelay = 10 * time.Millisecond
+	pub.c.Transport = &roundTripper{cb}
+	return pub, be
+}
+
+// waitNoDelivery waits until the publisher has no pending delivery. A callback
+// receives a notification before the publisher removes the delivery.
+func waitNoDelivery(t *testing.T, pub *Publisher) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if l, _ := pub.Deliveries.List(); len(l) == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("Delivery still pending after success")
+}
+
+func TestPublisher_retry(t *testing.T) {
+	topicURL := "http://localhost/publisher/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook"
+
+	cb := newTestCallback(2, http.StatusServiceUnavailable)
+	pub, be := testPublisher(t, cb)
+	pub.DeliveryFailed = func(topicURL, callbackURL string, err error) {
+		t.Errorf("Unexpected failed delivery: %v", err)
+	}
+
+	if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal("Register() =", err)
+	}
+
+	be.notifies(topicURL) <- testFeed(topicURL)
+
+	select {
+	case <-cb.received:
+	case <-time.After(time.Second):
+		t.Fatal("Notification not delivered")
+	}
+
+	waitNoDelivery(t, pub)
+	if n := cb.numRequests(); n != 3 {
+		t.Errorf("Expected 3 requests, got %v", n)
+	}
+}
+
+func TestPublisher_deliveryFailed(t *testing.T) {
+	tests := []struct {
+		status   int
+		requests int
+	}{
+		{http.StatusInternalServerError, 3},
+		{http.StatusNotFound, 1},
+	}
+
+	for _, test := range tests {
+		topicURL := "http://localhost/publisher/topic.atom"
+		callbackURL := "http://localhost/subscriber/webhook"
+
+		cb := newTestCallback(1000, test.status)
+		pub, be := testPublisher(t, cb)
+		// Retries after 10ms and 20ms, then gives up
+		pub.MaxDeliveryAge = 50 * time.Millisecond
+
+		failed := make(chan error, 1)
+		pub.DeliveryFailed = func(topic, callback string, err error) {
+			if topic != topicURL || callback != callbackURL {
+				t.Errorf("Invalid failed delivery: %v %v", topic, callback)
+			}
+			failed <- err
+		}
+
+		if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal("Register() =", err)
+		}
+
+		be.notifies(topicURL) <- testFeed(topicURL)
+
+		select {
+		case err := <-failed:
+			if err == nil {
+				t.Errorf("%v: expected an error", test.status)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v: delivery not given up", test.status)
+		}
+
+		if n := cb.numRequests(); n != test.requests {
+			t.Errorf("%v: expected %v requests, got %v", test.status, test.requests, n)
+		}
+		if l, _ := pub.Deliveries.List(); len(l) != 0 {
+			t.Errorf("%v: expected no pending delivery, got %v", test.status, len(l))
+		}
+	}
+}
+
+func TestPublisher_ResumeDeliveries(t *testing.T) {
+	topicURL := "http://localhost/publisher/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook"
+
+	cb := newTestCallback(0, 0)
+	pub, _ := testPublisher(t, cb)
+
+	if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal("Register() =", err)
+	}
+
+	pub.Deliveries.Put(&Delivery{
+		ID:          "pending",
+		Topic:       topicURL,
+		Callback:    callbackURL,
+		MediaType:   "application/atom+xml",
+		Body:        []byte("<feed xmlns='http://www.w3.org/2005/Atom'></feed>"),
+		Created:     time.Now(),
+		Attempts:    1,
+		NextAttempt: time.Now(),
+	})
+	if err := pub.ResumeDeliveries(); err != nil {
+		t.Fatal("ResumeDeliveries() =", err)
+	}
+
+	select {
+	case <-cb.received:
+	case <-time.After(time.Second):
+		t.Fatal("Pending delivery not resumed")
+	}
+
+	waitNoDelivery(t, pub)
+}
+
+func TestPublisher_ResumeDeliveries_twice(t *testing.T) {
+	topicURL := "http://localhost/publisher/topic.atom"
+	callbackURL := "http://slow.example.com/webhook"
+
+	cb := &slowHostCallback{
+		release: make(chan struct{}),
+		slow:    make(chan struct{}, 3),
+	}
+	pub, _ := testPublisher(t, cb)
+
+	if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal("Register() =", err)
+	}
+
+	pub.Deliveries.Put(&Delivery{
+		ID:          "pending",
+		Topic:       topicURL,
+		Callback:    callbackURL,
+		MediaType:   "application/atom+xml",
+		Body:        []byte("<feed xmlns='http://www.w3.org/2005/Atom'></feed>"),
+		Created:     time.Now(),
+		Attempts:    1,
+		NextAttempt: time.Now().Add(10 * time.Millisecond),
+	})
+	// The delivery is already scheduled
+	for i := 0; i < 2; i++ {
+		if err := pub.ResumeDeliveries(); err != nil {
+			t.Fatal("ResumeDeliveries() =", err)
+		}
+	}
+
+	select {
+	case <-cb.slow:
+	case <-time.After(time.Second):
+		t.Fatal("Pending delivery not resumed")
+	}
+
+	// The delivery is in flight
+	if err := pub.ResumeDeliveries(); err != nil {
+		t.Fatal("ResumeDeliveries() =", err)
+	}
+	close(cb.release)
+
+	waitNoDelivery(t, pub)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(cb.slow); n != 0 {
+		t.Errorf("Delivery sent %v more times", n)
+	}
+}
+
+func TestPublisher_deliveryRetryDelay(t *testing.T) {
+	pub := NewPublisher(newDummyBackend())
+	for _, retryDelay := range []time.Duration{0, -time.Second} {
+		pub.RetryDelay = retryDelay
+		if d := pub.deliveryRetryDelay(1); d != DefaultDeliveryRetryDelay {
+			t.Errorf("deliveryRetryDelay(RetryDelay = %v) = %v, want %v", retryDelay, d, DefaultDeliveryRetryDelay)
+		}
+	}
+
+	pub.RetryDelay = time.Second
+	if d := pub.deliveryRetryDelay(3); d != 4*time.Second {
+		t.Errorf("deliveryRetryDelay(3) = %v, want %v", d, 4*time.Second)
+	}
+}
+
+func TestPublisher_nilLogger(t *testing.T) {
+	topicURL := "http://localhost/publisher/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook"
+
+	cb := newTestCallback(1000, http.StatusNotFound)
+	pub, be := testPublisher(t, cb)
+	pub.Logger = nil
+
+	failed := make(chan error, 1)
+	pub.DeliveryFailed = func(topic, callback string, err error) {
+		failed <- err
+	}
+
+	if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal("Register() =", err)
+	}
+
+	be.notifies(topicURL) <- testFeed(topicURL)
+
+	select {
+	case <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("Delivery not given up")
+	}
+}
+
+// concurrencyCallback records the maximum number of concurrent requests, in
+// total and per host.
+type concurrencyCallback struct {
+	locker   sync.Mutex
+	inFlight map[string]int
+	total    int
+	maxHost  int
+	maxTotal int
+	received chan struct{}
+}
+
+func (cb *concurrencyCallback) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	cb.locker.Lock()
+	cb.inFlight[req.Host]++
+	cb.total++
+	if cb.inFlight[req.Host] > cb.maxHost {
+		cb.maxHost = cb.inFlight[req.Host]
+	}
+	if cb.total > cb.maxTotal {
+		cb.maxTotal = cb.total
+	}
+	cb.locker.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	cb.locker.Lock()
+	cb.inFlight[req.Host]--
+	cb.total--
+	cb.locker.Unlock()
+
+	resp.WriteHeader(http.StatusNoContent)
+	cb.received <- struct{}{}
+}
+
+func TestPublisher_workers(t *testing.T) {
+	const callbacks = 10
+
+	topicURL := "http://localhost/publisher/topic.atom"
+
+	cb := &concurrencyCallback{
+		inFlight: make(map[string]int),
+		received: make(chan struct{}, 2*callbacks),
+	}
+	pub, be := testPublisher(t, cb)
+	pub.Workers = 3
+	pub.MaxPerHost = 2
+
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		for i := 0; i < callbacks; i++ {
+			callbackURL := fmt.Sprintf("http://%v/webhook/%v", host, i)
+			if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+				t.Fatal("Register() =", err)
+			}
+		}
+	}
+
+	be.notifies(topicURL) <- testFeed(topicURL)
+
+	for i := 0; i < 2*callbacks; i++ {
+		select {
+		case <-cb.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Only %v notifications delivered", i)
+		}
+	}
+
+	cb.locker.Lock()
+	defer cb.locker.Unlock()
+	if cb.maxTotal > pub.Workers {
+		t.Errorf("Expected at most %v concurrent deliveries, got %v", pub.Workers, cb.maxTotal)
+	}
+	if cb.maxHost > pub.MaxPerHost {
+		t.Errorf("Expected at most %v concurrent deliveries per host, got %v", pub.MaxPerHost, cb.maxHost)
+	}
+}
+
+// slowHostCallback blocks requests to slow.example.com until release is
+// closed.
+type slowHostCallback struct {
+	release chan struct{}
+	slow    chan struct{}
+	fast    chan struct{}
+}
+
+func (cb *slowHostCallback) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Host == "slow.example.com" {
+		cb.slow <- struct{}{}
+		<-cb.release
+	} else {
+		cb.fast <- struct{}{}
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func TestPublisher_slowHost(t *testing.T) {
+	const slowCallbacks = 5
+
+	slowTopicURL := "http://localhost/publisher/slow.atom"
+	fastTopicURL := "http://localhost/publisher/fast.atom"
+
+	cb := &slowHostCallback{
+		release: make(chan struct{}),
+		slow:    make(chan struct{}, slowCallbacks),
+		fast:    make(chan struct{}, 1),
+	}
+	pub, be := testPublisher(t, cb)
+	pub.Workers = 3
+	pub.MaxPerHost = 2
+
+	for i := 0; i < slowCallbacks; i++ {
+		callbackURL := fmt.Sprintf("http://slow.example.com/webhook/%v", i)
+		if err := pub.Register(slowTopicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal("Register() =", err)
+		}
+	}
+	if err := pub.Register(fastTopicURL, "http://fast.example.com/webhook", "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal("Register() =", err)
+	}
+
+	be.notifies(slowTopicURL) <- testFeed(slowTopicURL)
+	for i := 0; i < pub.MaxPerHost; i++ {
+		select {
+		case <-cb.slow:
+		case <-time.After(time.Second):
+			t.Fatal("Slow host not reached")
+		}
+	}
+	// Give the publisher some time to hand the other slow deliveries to
+	// workers
+	time.Sleep(10 * time.Millisecond)
+
+	be.notifies(fastTopicURL) <- testFeed(fastTopicURL)
+	select {
+	case <-cb.fast:
+	case <-time.After(time.Second):
+		close(cb.release)
+		t.Fatal("Fast host delayed by the slow host")
+	}
+
+	close(cb.release)
+	for i := pub.MaxPerHost; i < slowCallbacks; i++ {
+		select {
+		case <-cb.slow:
+		case <-time.After(time.Second):
+			t.Fatalf("Only %v notifications delivered to the slow host", i)
+		}
+	}
+}
+
+func TestPublisher_hostQueueFull(t *testing.T) {
+	const slowCallbacks = 4
+
+	topicURL := "http://localhost/publisher/slow.atom"
+
+	cb := &slowHostCallback{
+		release: make(chan struct{}),
+		slow:    make(chan struct{}, 2*slowCallbacks),
+	}
+	pub, be := testPublisher(t, cb)
+	pub.Workers = 2
+	pub.MaxPerHost = 1
+	pub.MaxQueuedPerHost = 1
+
+	for i := 0; i < slowCallbacks; i++ {
+		callbackURL := fmt.Sprintf("http://slow.example.com/webhook/%v", i)
+		if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal("Register() =", err)
+		}
+	}
+
+	// One delivery is in flight, one is queued, one holds a worker waiting
+	// for the queue to have room and one waits for a worker
+	be.notifies(topicURL) <- testFeed(topicURL)
+	select {
+	case <-cb.slow:
+	case <-time.After(time.Second):
+		t.Fatal("Slow host not reached")
+	}
+
+	select {
+	case be.notifies(topicURL) <- testFeed(topicURL):
+		close(cb.release)
+		t.Fatal("Notification received while the slow host's queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(cb.release)
+	select {
+	case be.notifies(topicURL) <- testFeed(topicURL):
+	case <-time.After(time.Second):
+		t.Fatal("Notification not received after the slow host's queue was drained")
+	}
+	for i := 1; i < 2*slowCallbacks; i++ {
+		select {
+		case <-cb.slow:
+		case <-time.After(time.Second):
+			t.Fatalf("Only %v notifications delivered to the slow host", i)
+		}
+	}
+}
+
+type hangingTransport struct{}
+
+func (hangingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestPublisher_timeout(t *testing.T) {
+	topicURL := "http://localhost/publisher/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook"
+
+	pub, be := testPublisher(t, nil)
+	// This callback never replies
+	pub.c.Transport = hangingTransport{}
+	pub.Timeout = 10 * time.Millisecond
+	pub.MaxDeliveryAge = 5 * time.Millisecond
+
+	failed := make(chan error, 1)
+	pub.DeliveryFailed = func(topic, callback string, err error) {
+		failed <- err
+	}
+
+	if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal("Register() =", err)
+	}
+
+	be.notifies(topicURL) <- testFeed(topicURL)
+
+	select {
+	case <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("Delivery request not timed out")
+	}
+}
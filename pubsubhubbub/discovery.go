@@ -0,0 +1,179 @@
+package pubsubhubbub
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/emersion/go-ostatus/activitystream"
+)
+
+// ErrNoHub is returned when a topic doesn't advertise any hub.
+var ErrNoHub = errors.New("pubsubhubbub: no hub found")
+
+// parseLinkHeader parses Link header values, as defined in RFC 5988.
+func parseLinkHeader(values []string) []activitystream.Link {
+	var links []activitystream.Link
+	for _, v := range values {
+		for {
+			v = strings.TrimLeft(v, " \t,")
+			if !strings.HasPrefix(v, "<") {
+				break
+			}
+
+			i := strings.IndexByte(v, '>')
+			if i < 0 {
+				break
+			}
+			link := activitystream.Link{Href: v[1:i]}
+			v = v[i+1:]
+
+			// Parse parameters
+			for {
+				v = strings.TrimLeft(v, " \t")
+				if !strings.HasPrefix(v, ";") {
+					break
+				}
+				v = strings.TrimLeft(v[1:], " \t")
+
+				var name, value string
+				i := strings.IndexAny(v, "=;,")
+				if i < 0 {
+					name, v = v, ""
+				} else {
+					name, v = v[:i], v[i:]
+				}
+				name = strings.ToLower(strings.TrimSpace(name))
+
+				if strings.HasPrefix(v, "=") {
+					v = strings.TrimLeft(v[1:], " \t")
+					if strings.HasPrefix(v, `"`) {
+						end := strings.IndexByte(v[1:], '"')
+						if end < 0 {
+							value, v = v[1:], ""
+						} else {
+							value, v = v[1:end+1], v[end+2:]
+						}
+					} else {
+						end := strings.IndexAny(v, ";,")
+						if end < 0 {
+							end = len(v)
+						}
+						value, v = strings.TrimSpace(v[:end]), v[end:]
+					}
+				}
+
+				switch name {
+				case "rel":
+					link.Rel = value
+				case "type":
+					link.Type = value
+				case "title":
+					link.Title = value
+				}
+			}
+
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// hasRel checks if a space-separated list of relations contains rel.
+func hasRel(rels, rel string) bool {
+	for _, r := range strings.Fields(rels) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Discover fetches a topic and returns the hubs it advertises and its canonical
+// URL, as defined in section 4 of the specification. Links are searched in HTTP
+// Link headers first, then in Atom links. If the topic doesn't advertise a
+// canonical URL, topicURL is returned.
+func (s *Subscriber) Discover(ctx context.Context, topicURL string) (hubs []string, self string, err error) {
+	req, err := http.NewRequest(http.MethodGet, topicURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, "", HTTPError(resp.StatusCode)
+	}
+
+	// Resolve relative links against the final URL, after redirects
+	base := req.URL
+	if resp.Request != nil {
+		base = resp.Request.URL
+	}
+	collect := func(links []activitystream.Link) {
+		for _, link := range links {
+			u, err := base.Parse(link.Href)
+			if err != nil {
+				continue
+			}
+
+			if hasRel(link.Rel, RelHub) {
+				hubs = append(hubs, u.String())
+			}
+			if hasRel(link.Rel, "self") && self == "" {
+				self = u.String()
+			}
+		}
+	}
+
+	collect(parseLinkHeader(resp.Header["Link"]))
+
+	if len(hubs) == 0 {
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/atom+xml", "application/xml", "text/xml":
+			feed, err := activitystream.Read(resp.Body)
+			if err != nil {
+				return nil, "", err
+			}
+			collect(feed.Link)
+		}
+	}
+
+	if len(hubs) == 0 {
+		return nil, "", ErrNoHub
+	}
+	if self == "" {
+		self = topicURL
+	}
+	return hubs, self, nil
+}
+
+// SubscribeTopic discovers the hubs of a topic and subscribes to its canonical
+// URL. Hubs are tried in order until one of them accepts the subscription. It
+// returns the hub and the topic URL that have been used.
+func (s *Subscriber) SubscribeTopic(ctx context.Context, topicURL string, notifies chan<- Event) (hub, topic string, err error) {
+	hubs, topic, err := s.Discover(ctx, topicURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, hub = range hubs {
+		err = s.SubscribeContext(ctx, hub, topic, notifies)
+		if err == nil {
+			return hub, topic, nil
+		}
+		var deniedErr DeniedError
+		if errors.As(err, &deniedErr) || ctx.Err() != nil {
+			break
+		}
+	}
+	return "", "", err
+}
@@ -0,0 +1,116 @@
+package pubsubhubbub
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-ostatus/activitystream"
+)
+
+func TestParseLinkHeader(t *testing.T) {
+	values := []string{
+		`<https://hub.example.com/>; rel="hub", </feed.atom>; rel=self; type="application/atom+xml"`,
+		`<https://other.example.com/hub>;rel="hub alternate";title="Semi;colon, and comma"`,
+	}
+
+	want := []activitystream.Link{
+		{Href: "https://hub.example.com/", Rel: "hub"},
+		{Href: "/feed.atom", Rel: "self", Type: "application/atom+xml"},
+		{Href: "https://other.example.com/hub", Rel: "hub alternate", Title: "Semi;colon, and comma"},
+	}
+
+	if links := parseLinkHeader(values); !reflect.DeepEqual(links, want) {
+		t.Errorf("parseLinkHeader() = \n%+v\n, want \n%+v", links, want)
+	}
+}
+
+func TestSubscriber_Discover(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/header", func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Link", `</hub>; rel="hub"`)
+		resp.Header().Add("Link", `<http://example.com/canonical>; rel="self"`)
+		resp.Header().Set("Content-Type", "text/html")
+	})
+	mux.HandleFunc("/atom", func(resp http.ResponseWriter, req *http.Request) {
+		feed := &activitystream.Feed{
+			ID: "http://localhost/atom",
+			Link: []activitystream.Link{
+				{Rel: "self", Href: "http://localhost/atom"},
+				{Rel: "hub", Href: "http://hub1.example.com/"},
+				{Rel: "hub", Href: "http://hub2.example.com/"},
+			},
+		}
+		resp.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		feed.WriteTo(resp)
+	})
+	mux.HandleFunc("/nohub", func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "application/atom+xml")
+		(&activitystream.Feed{}).WriteTo(resp)
+	})
+
+	sub := NewSubscriber("http://localhost/subscriber", readAtomEvent)
+	sub.c.Transport = &roundTripper{mux}
+
+	tests := []struct {
+		url  string
+		hubs []string
+		self string
+	}{
+		{"http://localhost/header", []string{"http://localhost/hub"}, "http://example.com/canonical"},
+		{"http://localhost/atom", []string{"http://hub1.example.com/", "http://hub2.example.com/"}, "http://localhost/atom"},
+	}
+
+	for _, test := range tests {
+		hubs, self, err := sub.Discover(context.Background(), test.url)
+		if err != nil {
+			t.Errorf("Discover(%q) = %v", test.url, err)
+			continue
+		}
+		if !reflect.DeepEqual(hubs, test.hubs) {
+			t.Errorf("Discover(%q): expected hubs %v but got %v", test.url, test.hubs, hubs)
+		}
+		if self != test.self {
+			t.Errorf("Discover(%q): expected self %v but got %v", test.url, test.self, self)
+		}
+	}
+
+	if _, _, err := sub.Discover(context.Background(), "http://localhost/nohub"); err != ErrNoHub {
+		t.Errorf("Discover(no hub) = %v, want %v", err, ErrNoHub)
+	}
+}
+
+func TestSubscriber_SubscribeTopic(t *testing.T) {
+	publisherURL := "http://localhost/publisher"
+	hubURL := publisherURL + "/hub"
+	topicURL := publisherURL + "/topic.atom"
+
+	be := newDummyBackend()
+	pub := NewPublisher(be)
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+
+	mux := http.NewServeMux()
+	mux.Handle("/publisher/hub", pub)
+	mux.HandleFunc("/publisher/feed", func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Link", "<"+hubURL+`>; rel="hub"`)
+		resp.Header().Add("Link", "<"+topicURL+`>; rel="self"`)
+	})
+
+	pub.c.Transport = &roundTripper{sub}
+	sub.c.Transport = &roundTripper{mux}
+
+	hub, topic, err := sub.SubscribeTopic(context.Background(), publisherURL+"/feed", make(chan Event))
+	if err != nil {
+		t.Fatal("SubscribeTopic() =", err)
+	}
+	if hub != hubURL {
+		t.Errorf("Invalid hub: expected %v but got %v", hubURL, hub)
+	}
+	if topic != topicURL {
+		t.Errorf("Invalid topic: expected %v but got %v", topicURL, topic)
+	}
+	if be.notifies(topicURL) == nil {
+		t.Error("Expected the publisher to subscribe to the canonical topic")
+	}
+}
@@ -0,0 +1,88 @@
+package pubsubhubbub_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-ostatus/activitystream"
+	"github.com/emersion/go-ostatus/jsonfeed"
+	"github.com/emersion/go-ostatus/pubsubhubbub"
+	"github.com/emersion/go-ostatus/rss"
+)
+
+func TestReadEvent(t *testing.T) {
+	topicURL := "http://localhost/topic"
+
+	tests := []struct {
+		contentType string
+		event       pubsubhubbub.Event
+	}{
+		{"application/atom+xml", &activitystream.Feed{
+			ID:    topicURL,
+			Title: "Test notification",
+			Link: []activitystream.Link{
+				{Rel: "self", Type: "application/atom+xml", Href: topicURL},
+			},
+		}},
+		{"application/rss+xml; charset=utf-8", rss.NewFeed(&rss.Channel{
+			Title:    "Test notification",
+			AtomLink: []rss.AtomLink{{Rel: "self", Href: topicURL}},
+		})},
+		{"application/feed+json", &jsonfeed.Feed{
+			Version: jsonfeed.Version,
+			Title:   "Test notification",
+			FeedURL: topicURL,
+		}},
+	}
+
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := test.event.WriteTo(&b); err != nil {
+			t.Fatal("WriteTo() =", err)
+		}
+
+		event, err := pubsubhubbub.ReadEvent(test.contentType, &b)
+		if err != nil {
+			t.Errorf("ReadEvent(%q) = %v", test.contentType, err)
+			continue
+		}
+		if event.MediaType() != test.event.MediaType() {
+			t.Errorf("ReadEvent(%q): expected media type %v but got %v", test.contentType, test.event.MediaType(), event.MediaType())
+		}
+		if event.Topic() != topicURL {
+			t.Errorf("ReadEvent(%q): expected topic %v but got %v", test.contentType, topicURL, event.Topic())
+		}
+	}
+
+	if _, err := pubsubhubbub.ReadEvent("text/plain", new(bytes.Buffer)); err == nil {
+		t.Error("Expected an error for an unsupported media type")
+	}
+}
+
+// TestRegisterEventFormat should be run with the race detector.
+func TestRegisterEventFormat(t *testing.T) {
+	const mediaType = "text/x-test-event"
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pubsubhubbub.RegisterEventFormat(mediaType, func(mediaType string, body io.Reader) (pubsubhubbub.Event, error) {
+			return rss.ReadEvent(mediaType, body)
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		pubsubhubbub.ReadEvent(mediaType, strings.NewReader(""))
+	}()
+	wg.Wait()
+
+	var b bytes.Buffer
+	rss.NewFeed(&rss.Channel{Title: "Test notification"}).WriteTo(&b)
+	if _, err := pubsubhubbub.ReadEvent(mediaType, &b); err != nil {
+		t.Errorf("ReadEvent(%q) = %v", mediaType, err)
+	}
+}
@@ -0,0 +1,53 @@
+package pubsubhubbub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// An EventHandler handles notifications received by a Subscriber.
+type EventHandler interface {
+	// HandleEvent handles a notification about a topic. ctx is canceled when the
+	// hub gives up on the request or when the subscriber shuts down.
+	//
+	// If HandleEvent returns a HTTPError, its status code is sent to the hub.
+	// Other errors are reported with 503 Service Unavailable, so that the hub
+	// retries later.
+	HandleEvent(ctx context.Context, topic string, event Event) error
+}
+
+// EventHandlerFunc is an adapter to use a function as an EventHandler.
+type EventHandlerFunc func(ctx context.Context, topic string, event Event) error
+
+// HandleEvent implements EventHandler.
+func (f EventHandlerFunc) HandleEvent(ctx context.Context, topic string, event Event) error {
+	return f(ctx, topic, event)
+}
+
+type channelHandler chan<- Event
+
+func (ch channelHandler) HandleEvent(ctx context.Context, topic string, event Event) error {
+	select {
+	case ch <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ChannelHandler returns an EventHandler that sends notifications to notifies.
+// It blocks until the notification is received or ctx is done.
+func ChannelHandler(notifies chan<- Event) EventHandler {
+	return channelHandler(notifies)
+}
+
+// eventErrorStatus returns the status code sent to the hub when an
+// EventHandler fails.
+func eventErrorStatus(err error) int {
+	var httpErr HTTPError
+	if errors.As(err, &httpErr) {
+		return int(httpErr)
+	}
+	return http.StatusServiceUnavailable
+}
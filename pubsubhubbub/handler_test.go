@@ -0,0 +1,82 @@
+package pubsubhubbub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSubscriber_Handler(t *testing.T) {
+	hubURL := "http://localhost/hub"
+	topicURL := "http://localhost/topic.atom"
+
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	// This hub accepts requests but never verifies them
+	sub.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusAccepted)
+	})}
+
+	var handlerErr error
+	received := make(chan string, 1)
+	sub.Handler = EventHandlerFunc(func(ctx context.Context, topic string, event Event) error {
+		received <- topic
+		return handlerErr
+	})
+
+	go sub.Subscribe(hubURL, topicURL, nil)
+
+	// Wait for the subscription to be pending
+	var callbackURL, secret string
+	for callbackURL == "" {
+		sub.locker.Lock()
+		if s, ok := sub.subscriptions[hubTopic{hubURL, topicURL}]; ok {
+			callbackURL, secret = s.callbackURL, s.secret
+		}
+		sub.locker.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{nil, http.StatusOK},
+		{errors.New("busy"), http.StatusServiceUnavailable},
+		{HTTPError(http.StatusGone), http.StatusGone},
+	}
+
+	for _, test := range tests {
+		handlerErr = test.err
+		w := postNotification(context.Background(), sub, callbackURL, secret, testFeed(topicURL))
+		if w.Code != test.status {
+			t.Errorf("HandleEvent() = %v: expected status %v, got %v", test.err, test.status, w.Code)
+		}
+		if topic := <-received; topic != topicURL {
+			t.Errorf("Invalid topic: expected %v but got %v", topicURL, topic)
+		}
+	}
+}
+
+func TestChannelHandler(t *testing.T) {
+	topicURL := "http://localhost/topic.atom"
+
+	notifies := make(chan Event, 1)
+	h := ChannelHandler(notifies)
+
+	if err := h.HandleEvent(context.Background(), topicURL, testFeed(topicURL)); err != nil {
+		t.Fatal("HandleEvent() =", err)
+	}
+	if event := <-notifies; event.Topic() != topicURL {
+		t.Errorf("Invalid topic: expected %v but got %v", topicURL, event.Topic())
+	}
+
+	// Nobody reads from the channel
+	notifies <- testFeed(topicURL)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.HandleEvent(ctx, topicURL, testFeed(topicURL)); err != context.DeadlineExceeded {
+		t.Errorf("HandleEvent() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
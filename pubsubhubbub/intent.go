@@ -0,0 +1,73 @@
+package pubsubhubbub
+
+import (
+	"time"
+)
+
+// DefaultIntentTimeout is the default duration during which a hub can verify a
+// request.
+var DefaultIntentTimeout = time.Hour
+
+// An intent is a subscription or unsubscription request waiting for the hub's
+// verification.
+type intent struct {
+	mode    string
+	expires time.Time
+}
+
+// addIntent records a request sent to the hub. It must be called with
+// s.locker held.
+func (s *Subscriber) addIntent(sub *subscription, mode string) *intent {
+	in := &intent{
+		mode:    mode,
+		expires: time.Now().Add(s.IntentTimeout),
+	}
+	sub.intents = append(sub.intents, in)
+	return in
+}
+
+// removeIntent forgets about a request, for instance because the hub rejected
+// it. It must be called with s.locker held.
+func (sub *subscription) removeIntent(in *intent) {
+	for i, other := range sub.intents {
+		if other == in {
+			sub.intents = append(sub.intents[:i], sub.intents[i+1:]...)
+			return
+		}
+	}
+}
+
+// hasIntent checks if a request is waiting for the hub's verification. It must
+// be called with s.locker held.
+func (sub *subscription) hasIntent(mode string) bool {
+	now := time.Now()
+	for _, in := range sub.intents {
+		if in.mode == mode && now.Before(in.expires) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIntent checks that a verification matches a request, and forgets about
+// the request. The lease isn't compared: the hub is free to grant a lease
+// different from the requested one. Expired intents are removed. It must be
+// called with s.locker held.
+func (sub *subscription) matchIntent(mode string) bool {
+	now := time.Now()
+
+	intents := sub.intents[:0]
+	matched := false
+	for _, in := range sub.intents {
+		if !now.Before(in.expires) {
+			continue
+		}
+		if !matched && in.mode == mode {
+			matched = true
+			continue
+		}
+		intents = append(intents, in)
+	}
+	sub.intents = intents
+	return matched
+}
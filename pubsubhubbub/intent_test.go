@@ -0,0 +1,188 @@
+package pubsubhubbub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func verify(h http.Handler, callbackURL, mode, topic, lease string) int {
+	q := url.Values{
+		"hub.mode":      {mode},
+		"hub.topic":     {topic},
+		"hub.challenge": {"challenge"},
+	}
+	if lease != "" {
+		q.Set("hub.lease_seconds", lease)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, callbackURL+"&"+q.Encode(), nil))
+	return w.Code
+}
+
+func TestSubscriber_intents(t *testing.T) {
+	hubURL := "http://localhost/hub"
+	topicURL := "http://localhost/topic.atom"
+
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	sub.Lease = time.Hour
+	// This hub accepts requests but never verifies them
+	sub.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusAccepted)
+	})}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sub.Subscribe(hubURL, topicURL, make(chan Event))
+	}()
+
+	var callbackURL string
+	for callbackURL == "" {
+		sub.locker.Lock()
+		if s, ok := sub.subscriptions[hubTopic{hubURL, topicURL}]; ok {
+			callbackURL = s.callbackURL
+		}
+		sub.locker.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	tests := []struct {
+		mode, lease string
+		code        int
+	}{
+		{"unsubscribe", "", http.StatusNotFound},   // Not requested
+		{"subscribe", "7200", http.StatusOK},       // Longer than requested
+		{"subscribe", "1800", http.StatusNotFound}, // Already verified
+		{"unsubscribe", "", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		if code := verify(sub, callbackURL, test.mode, topicURL, test.lease); code != test.code {
+			t.Errorf("%v (lease %q): expected status %v but got %v", test.mode, test.lease, test.code, code)
+		}
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal("Subscribe() =", err)
+	}
+
+	sub.locker.Lock()
+	_, ok := sub.subscriptions[hubTopic{hubURL, topicURL}]
+	sub.locker.Unlock()
+	if !ok {
+		t.Fatal("Subscription removed by an unsolicited verification")
+	}
+}
+
+func TestSubscriber_longerLease(t *testing.T) {
+	s := newWebSubServers(t)
+	s.pub.WebSub = false
+	// The hub raises this lease to its MinLease
+	s.sub.Lease = 30 * time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.sub.SubscribeContext(ctx, s.hub.URL, "http://example.org/topic.atom", make(chan Event)); err != nil {
+		t.Fatal("SubscribeContext() =", err)
+	}
+
+	if leaseEnd := s.waitState(t); time.Until(leaseEnd) <= s.sub.Lease {
+		t.Errorf("Expected the hub to grant a longer lease, got a lease ending at %v", leaseEnd)
+	}
+}
+
+func TestSubscriber_intentTimeout(t *testing.T) {
+	hubURL := "http://localhost/hub"
+	topicURL := "http://localhost/topic.atom"
+
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	sub.IntentTimeout = 10 * time.Millisecond
+	sub.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusAccepted)
+	})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- sub.SubscribeContext(ctx, hubURL, topicURL, make(chan Event))
+	}()
+
+	var callbackURL string
+	for callbackURL == "" {
+		sub.locker.Lock()
+		if s, ok := sub.subscriptions[hubTopic{hubURL, topicURL}]; ok {
+			callbackURL = s.callbackURL
+		}
+		sub.locker.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if code := verify(sub, callbackURL, "subscribe", topicURL, "60"); code != http.StatusNotFound {
+		t.Errorf("Expected status %v for an expired intent, got %v", http.StatusNotFound, code)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestSubscriber_spoofedDenial(t *testing.T) {
+	hubURL := "http://localhost/hub"
+	topicURL := "http://localhost/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook?id=test"
+
+	store := NewMemoryStore()
+	store.Put(&Subscription{
+		Hub:      hubURL,
+		Topic:    topicURL,
+		Callback: callbackURL,
+		LeaseEnd: time.Now().Add(time.Hour),
+	})
+	sub, err := NewSubscriberWithStore("http://localhost/subscriber/webhook", readAtomEvent, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// This hub accepts renewals but doesn't verify them
+	renewals := make(chan struct{}, 2)
+	sub.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.FormValue("hub.mode") == "subscribe" {
+			renewals <- struct{}{}
+		}
+		resp.WriteHeader(http.StatusAccepted)
+	})}
+
+	subscribed := func() bool {
+		sub.locker.Lock()
+		defer sub.locker.Unlock()
+		_, ok := sub.subscriptions[hubTopic{hubURL, topicURL}]
+		return ok
+	}
+
+	if code := verify(sub, callbackURL, "denied", topicURL, ""); code != http.StatusNotFound {
+		t.Errorf("Expected status %v for an unsolicited denial, got %v", http.StatusNotFound, code)
+	}
+	if !subscribed() {
+		t.Fatal("Subscription removed by an unsolicited denial")
+	}
+
+	// The subscriber asks the hub to confirm the subscription
+	select {
+	case <-renewals:
+	case <-time.After(time.Second):
+		t.Fatal("Subscription not renewed after an unsolicited denial")
+	}
+
+	// The hub denies the renewal: the subscription has really been revoked
+	if code := verify(sub, callbackURL, "denied", topicURL, ""); code != http.StatusOK {
+		t.Errorf("Expected status %v for a denial, got %v", http.StatusOK, code)
+	}
+	if subscribed() {
+		t.Error("Subscription not removed after a denied renewal")
+	}
+}
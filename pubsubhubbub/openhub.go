@@ -0,0 +1,144 @@
+package pubsubhubbub
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/emersion/go-ostatus/activitystream"
+)
+
+// A PublishBackend is a Backend that accepts publish pings, sent by publishers
+// to notify the hub that a topic has been updated.
+type PublishBackend interface {
+	Backend
+
+	// Publish notifies the backend that a topic has been updated.
+	Publish(topic string) error
+}
+
+type openHubTopic struct {
+	notifies chan<- Event
+	done     chan struct{}
+	senders  sync.WaitGroup
+
+	// fetching serializes fetches, seen is protected by it
+	fetching sync.Mutex
+	seen     map[string]activitystream.Time
+}
+
+// An OpenHub is a PublishBackend for third-party publishers. It fetches topics
+// when it's pinged, and notifies subscribers about new and updated entries
+// only.
+type OpenHub struct {
+	// Fetch retrieves a topic. It defaults to activitystream.Get.
+	Fetch func(topic string) (*activitystream.Feed, error)
+
+	topics map[string]*openHubTopic
+	locker sync.Mutex
+}
+
+// NewOpenHub creates a new open hub.
+func NewOpenHub() *OpenHub {
+	return &OpenHub{
+		Fetch:  activitystream.Get,
+		topics: make(map[string]*openHubTopic),
+	}
+}
+
+// seenEntries returns the update time of each entry in feed.
+func seenEntries(feed *activitystream.Feed) map[string]activitystream.Time {
+	seen := make(map[string]activitystream.Time, len(feed.Entry))
+	for _, entry := range feed.Entry {
+		if entry.ID != "" {
+			seen[entry.ID] = entry.Updated
+		}
+	}
+	return seen
+}
+
+// Subscribe implements Backend. The topic is fetched to find out which entries
+// already exist.
+func (h *OpenHub) Subscribe(topic string, notifies chan<- Event) error {
+	feed, err := h.Fetch(topic)
+	if err != nil {
+		return DeniedError("cannot fetch topic: " + err.Error())
+	}
+
+	h.locker.Lock()
+	defer h.locker.Unlock()
+
+	if _, ok := h.topics[topic]; ok {
+		return errors.New("pubsubhubbub: already subscribed")
+	}
+	h.topics[topic] = &openHubTopic{
+		notifies: notifies,
+		done:     make(chan struct{}),
+		seen:     seenEntries(feed),
+	}
+	return nil
+}
+
+// Unsubscribe implements Backend.
+func (h *OpenHub) Unsubscribe(notifies chan<- Event) error {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+
+	for topic, t := range h.topics {
+		if t.notifies != notifies {
+			continue
+		}
+
+		delete(h.topics, topic)
+		close(t.done)
+		// Wait for Publish calls to give up before closing the channel
+		go func() {
+			t.senders.Wait()
+			close(notifies)
+		}()
+		return nil
+	}
+	return errors.New("pubsubhubbub: no such subscription")
+}
+
+// Publish implements PublishBackend. Pings for topics without subscribers are
+// ignored.
+func (h *OpenHub) Publish(topic string) error {
+	h.locker.Lock()
+	t, ok := h.topics[topic]
+	if ok {
+		t.senders.Add(1)
+	}
+	h.locker.Unlock()
+	if !ok {
+		return nil
+	}
+	defer t.senders.Done()
+
+	t.fetching.Lock()
+	defer t.fetching.Unlock()
+
+	feed, err := h.Fetch(topic)
+	if err != nil {
+		return err
+	}
+
+	var entries []*activitystream.Entry
+	for _, entry := range feed.Entry {
+		if updated, ok := t.seen[entry.ID]; entry.ID == "" || !ok || updated != entry.Updated {
+			entries = append(entries, entry)
+		}
+	}
+	t.seen = seenEntries(feed)
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	notif := *feed
+	notif.Entry = entries
+	select {
+	case t.notifies <- &notif:
+	case <-t.done:
+	}
+	return nil
+}
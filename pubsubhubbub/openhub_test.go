@@ -0,0 +1,117 @@
+package pubsubhubbub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ostatus/activitystream"
+)
+
+func ping(h http.Handler, topicURL string) *httptest.ResponseRecorder {
+	data := url.Values{"hub.mode": {"publish"}, "hub.url": {topicURL}}
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/hub", strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestOpenHub(t *testing.T) {
+	hubURL := "http://localhost/hub"
+	topicURL := "http://example.org/topic.atom"
+
+	var locker sync.Mutex
+	feed := testFeed(topicURL)
+	feed.Entry = []*activitystream.Entry{
+		{ID: "tag:example.org,2017:1", Updated: "2017-01-01T00:00:00Z"},
+	}
+	setEntries := func(entries ...*activitystream.Entry) {
+		locker.Lock()
+		defer locker.Unlock()
+		clone := *feed
+		clone.Entry = entries
+		feed = &clone
+	}
+
+	be := NewOpenHub()
+	be.Fetch = func(topic string) (*activitystream.Feed, error) {
+		if topic != topicURL {
+			return nil, activitystream.HTTPError(http.StatusNotFound)
+		}
+		locker.Lock()
+		defer locker.Unlock()
+		return feed, nil
+	}
+
+	pub := NewPublisher(be)
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	pub.c.Transport = &roundTripper{sub}
+	sub.c.Transport = &roundTripper{pub}
+
+	notifies := make(chan Event, 1)
+	if err := sub.Subscribe(hubURL, topicURL, notifies); err != nil {
+		t.Fatal("Subscribe() =", err)
+	}
+
+	expectEntries := func(ids ...string) {
+		t.Helper()
+
+		if w := ping(pub, topicURL); w.Code != http.StatusNoContent {
+			t.Fatalf("Ping failed: %v", w.Code)
+		}
+
+		if len(ids) == 0 {
+			select {
+			case <-notifies:
+				t.Error("Unexpected notification")
+			case <-time.After(50 * time.Millisecond):
+			}
+			return
+		}
+
+		select {
+		case event := <-notifies:
+			feed := event.(*activitystream.Feed)
+			var got []string
+			for _, entry := range feed.Entry {
+				got = append(got, entry.ID)
+			}
+			if strings.Join(got, " ") != strings.Join(ids, " ") {
+				t.Errorf("Expected entries %v but got %v", ids, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Notification not received")
+		}
+	}
+
+	// Nothing changed since the subscription
+	expectEntries()
+
+	// A new entry
+	first := &activitystream.Entry{ID: "tag:example.org,2017:1", Updated: "2017-01-01T00:00:00Z"}
+	second := &activitystream.Entry{ID: "tag:example.org,2017:2", Updated: "2017-01-02T00:00:00Z"}
+	setEntries(second, first)
+	expectEntries(second.ID)
+
+	// An updated entry
+	first = &activitystream.Entry{ID: first.ID, Updated: "2017-01-03T00:00:00Z"}
+	setEntries(first, second)
+	expectEntries(first.ID)
+
+	// A topic that cannot be fetched is denied
+	if err := sub.Subscribe(hubURL, "http://example.org/missing.atom", make(chan Event)); err == nil {
+		t.Error("Expected subscription to a missing topic to fail")
+	}
+}
+
+func TestPublisher_publishUnsupported(t *testing.T) {
+	pub := NewPublisher(newDummyBackend())
+	if w := ping(pub, "http://example.org/topic.atom"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %v but got %v", http.StatusBadRequest, w.Code)
+	}
+}
@@ -2,9 +2,7 @@ package pubsubhubbub
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/hex"
+	"context"
 	"errors"
 	"io"
 	"net/http"
@@ -13,13 +11,19 @@ import (
 	"sync"
 	"time"
 
-	"log"
+	"log/slog"
 )
 
 // DefaultLease is the default duration of a lease, if none is provided by the
 // subscriber.
 var DefaultLease = 24 * time.Hour
 
+// Default bounds of the leases granted by a Publisher.
+var (
+	DefaultMinLease = time.Minute
+	DefaultMaxLease = 30 * 24 * time.Hour
+)
+
 // A Backend is used to build a publisher.
 type Backend interface {
 	// Subscribe sends content notifications about a topic to notifies in a new
@@ -36,58 +40,74 @@ type pubSubscription struct {
 	notifies  chan Event
 	callbacks map[string]*pubCallback
 	locker    sync.Mutex
+
+	// unsubscribed is true once the backend has been unsubscribed
+	unsubscribed bool
+
+	// The last event, kept for redelivery
+	lastMediaType string
+	lastBody      []byte
 }
 
 type pubCallback struct {
-	secret string
-	timer  *time.Timer
+	secret   string
+	leaseEnd time.Time
+	timer    Timer
+
+	// Status of the last delivery
+	lastDelivery time.Time
+	lastErr      error
 }
 
-func (s *pubSubscription) receive(c *http.Client) error {
-	for notif := range s.notifies {
+func (p *Publisher) receive(topicURL string, s *pubSubscription) {
+	for {
+		var notif Event
+		select {
+		case n, ok := <-s.notifies:
+			if !ok {
+				return
+			}
+			notif = n
+		case <-p.done:
+			// The backend should have closed notifies, but don't wait for it
+			return
+		}
+
 		mediaType := notif.MediaType()
 		var b bytes.Buffer
 		if err := notif.WriteTo(&b); err != nil {
-			return err
+			p.logger().Error("cannot write notification", "topic", topicURL, "err", err)
+			continue
 		}
 
-		// TODO: retry if a request fails
 		s.locker.Lock()
-		for callbackURL, cb := range s.callbacks {
-			go func() {
-				body := bytes.NewReader(b.Bytes())
-				req, err := http.NewRequest(http.MethodPost, callbackURL, body)
-				if err != nil {
-					log.Println("pubsubhubbub: failed create notification:", err)
-					return
-				}
-
-				req.Header.Set("Content-Type", mediaType)
-
-				if cb.secret != "" {
-					h := hmac.New(sha1.New, []byte(cb.secret))
-					h.Write(b.Bytes())
-					sig := hex.EncodeToString(h.Sum(nil))
-					req.Header.Set("X-Hub-Signature", "sha1="+sig)
-				}
-
-				resp, err := c.Do(req)
-				if err != nil {
-					log.Println("pubsubhubbub: failed to push notification:", err)
-					return
-				}
-				resp.Body.Close()
-
-				if resp.StatusCode/100 != 2 {
-					log.Println("pubsubhubbub: failed to push notification:", resp.StatusCode, resp.Status)
-					return
-				}
-			}()
+		s.lastMediaType = mediaType
+		s.lastBody = b.Bytes()
+		callbacks := make([]string, 0, len(s.callbacks))
+		for callbackURL := range s.callbacks {
+			callbacks = append(callbacks, callbackURL)
 		}
 		s.locker.Unlock()
-	}
 
-	return nil
+		now := p.Clock.Now()
+		for _, callbackURL := range callbacks {
+			id, err := randomString(16)
+			if err != nil {
+				p.logger().Error("cannot create delivery", "topic", topicURL, "callback", callbackURL, "err", err)
+				continue
+			}
+
+			p.enqueue(&Delivery{
+				ID:          id,
+				Topic:       topicURL,
+				Callback:    callbackURL,
+				MediaType:   mediaType,
+				Body:        b.Bytes(),
+				Created:     now,
+				NextAttempt: now,
+			})
+		}
+	}
 }
 
 // A Publisher distributes content notifications.
@@ -97,19 +117,149 @@ type Publisher struct {
 	// ends.
 	SubscriptionState func(topicURL, callbackURL, secret string, leaseEnd time.Time)
 
+	// Clock is used to manage leases and to schedule deliveries.
+	Clock Clock
+	// MinLease and MaxLease bound the leases requested by subscribers. If
+	// zero, there is no bound.
+	MinLease, MaxLease time.Duration
+
+	// Deliveries stores failed deliveries until they're retried.
+	Deliveries DeliveryStore
+	// RetryDelay is the delay before retrying a failed delivery. It doubles
+	// after each attempt. If not positive, DefaultDeliveryRetryDelay is used.
+	RetryDelay time.Duration
+	// MaxDeliveryAge is the maximum duration during which a failed delivery is
+	// retried.
+	MaxDeliveryAge time.Duration
+	// DeliveryFailed specifies an optional callback function that is called
+	// when a delivery is given up.
+	DeliveryFailed func(topicURL, callbackURL string, err error)
+
+	// Workers is the number of concurrent deliveries. When all workers are
+	// busy, notifications from the Backend are not received anymore.
+	Workers int
+	// MaxPerHost is the maximum number of concurrent deliveries to a single
+	// host. Deliveries to a host that reached this limit are queued without
+	// holding a worker. If zero, there is no limit.
+	MaxPerHost int
+	// MaxQueuedPerHost is the maximum number of deliveries queued for a single
+	// host. When a host's queue is full, workers wait for it to have room. If
+	// zero, there is no limit.
+	MaxQueuedPerHost int
+	// Timeout is the timeout of a delivery request. If zero, there is no
+	// timeout.
+	Timeout time.Duration
+
+	// SignatureAlgorithm is the algorithm used to sign content distribution
+	// requests to subscribers that provided a secret. Subscriptions with a
+	// secret are rejected if it isn't supported.
+	SignatureAlgorithm string
+
+	// WebSub enables WebSub conformance: content distribution requests carry
+	// Link headers for the hub and the topic, and callbacks answering with
+	// 410 Gone are unsubscribed.
+	WebSub bool
+	// HubURL is the URL of the hub, advertised in WebSub Link headers. If
+	// empty, it's derived from the subscription requests received by the hub,
+	// so it must be set if a proxy rewrites the hub's URL.
+	HubURL string
+	// Logger receives failed deliveries and subscription requests. Log records
+	// have topic, callback and err attributes where relevant. If nil,
+	// slog.Default() is used.
+	Logger *slog.Logger
+
 	be            Backend
 	c             *http.Client
+	store         SubscriptionStore
 	subscriptions map[string]*pubSubscription
+	hosts         map[string]*hostQueue
 	locker        sync.Mutex
+
+	jobs         chan *Delivery
+	startWorkers sync.Once
+	retries      map[string]Timer
+	delivering   map[string]bool
+	// requestHubURL is the hub URL derived from subscription requests.
+	requestHubURL string
+
+	// Shutdown state. wg tracks in-flight verifications, deliveries and
+	// goroutines receiving events. ctx is canceled when Shutdown gives up
+	// waiting for them.
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-// NewPublisher creates a new publisher.
+// NewPublisher creates a new publisher. Subscriptions are kept in memory.
 func NewPublisher(be Backend) *Publisher {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Publisher{
-		be:            be,
-		c:             new(http.Client),
-		subscriptions: make(map[string]*pubSubscription),
+		Clock:              SystemClock,
+		MinLease:           DefaultMinLease,
+		MaxLease:           DefaultMaxLease,
+		Deliveries:         NewMemoryDeliveryStore(),
+		RetryDelay:         DefaultDeliveryRetryDelay,
+		MaxDeliveryAge:     DefaultMaxDeliveryAge,
+		Workers:            DefaultWorkers,
+		MaxPerHost:         DefaultMaxPerHost,
+		MaxQueuedPerHost:   DefaultMaxQueuedPerHost,
+		Timeout:            DefaultDeliveryTimeout,
+		SignatureAlgorithm: DefaultSignatureAlgorithm,
+		be:                 be,
+		c:                  new(http.Client),
+		store:              NewMemoryStore(),
+		subscriptions:      make(map[string]*pubSubscription),
+		hosts:              make(map[string]*hostQueue),
+		jobs:               make(chan *Delivery),
+		Logger:             slog.Default(),
+		retries:            make(map[string]Timer),
+		delivering:         make(map[string]bool),
+		done:               make(chan struct{}),
+		ctx:                ctx,
+		cancel:             cancel,
+	}
+}
+
+func (p *Publisher) logger() *slog.Logger {
+	if p.Logger == nil {
+		return slog.Default()
+	}
+	return p.Logger
+}
+
+// NewPublisherWithStore creates a new publisher that persists subscriptions in
+// store. Subscriptions already in store are restored, expired ones are removed.
+// Leases are managed with clock, or with SystemClock if nil. Changing the
+// publisher's Clock afterwards doesn't affect the restored leases.
+func NewPublisherWithStore(be Backend, store SubscriptionStore, clock Clock) (*Publisher, error) {
+	p := NewPublisher(be)
+	p.store = store
+	if clock != nil {
+		p.Clock = clock
+	}
+
+	l, err := store.List()
+	if err != nil {
+		return nil, err
 	}
+
+	now := p.Clock.Now()
+	for _, sub := range l {
+		if !sub.LeaseEnd.After(now) {
+			if err := store.Delete(sub.Topic, sub.Callback); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := p.Register(sub.Topic, sub.Callback, sub.Secret, sub.LeaseEnd); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
 }
 
 func (p *Publisher) createSubscription(topicURL string) (s *pubSubscription, created bool) {
@@ -130,11 +280,22 @@ func (p *Publisher) createSubscription(topicURL string) (s *pubSubscription, cre
 func (p *Publisher) subscribeIfNotExist(topicURL string) (*pubSubscription, error) {
 	s, ok := p.createSubscription(topicURL)
 	if !ok {
+		if !p.acquire() {
+			return nil, ErrShutdown
+		}
+
 		if err := p.be.Subscribe(topicURL, s.notifies); err != nil {
+			p.wg.Done()
+			p.locker.Lock()
+			delete(p.subscriptions, topicURL)
+			p.locker.Unlock()
 			return nil, err
 		}
 
-		go s.receive(p.c)
+		go func() {
+			defer p.wg.Done()
+			p.receive(topicURL, s)
+		}()
 	}
 
 	return s, nil
@@ -143,8 +304,15 @@ func (p *Publisher) subscribeIfNotExist(topicURL string) (*pubSubscription, erro
 // Register registers an existing subscription. It can be used to restore
 // subscriptions when restarting the server.
 func (p *Publisher) Register(topicURL, callbackURL, secret string, leaseEnd time.Time) error {
-	lease := leaseEnd.Sub(time.Now())
-	if lease <= 0 {
+	if p.isClosed() {
+		return ErrShutdown
+	}
+	if secret != "" {
+		if err := checkSignatureAlgorithm(p.SignatureAlgorithm); err != nil {
+			return err
+		}
+	}
+	if !leaseEnd.After(p.Clock.Now()) {
 		return nil
 	}
 
@@ -153,13 +321,38 @@ func (p *Publisher) Register(topicURL, callbackURL, secret string, leaseEnd time
 		return err
 	}
 
+	return p.register(s, topicURL, callbackURL, secret, leaseEnd)
+}
+
+func (p *Publisher) register(s *pubSubscription, topicURL, callbackURL, secret string, leaseEnd time.Time) error {
+	err := p.store.Put(&Subscription{
+		Topic:    topicURL,
+		Callback: callbackURL,
+		Secret:   secret,
+		LeaseEnd: leaseEnd,
+	})
+	if err != nil {
+		return err
+	}
+
+	if p.isClosed() {
+		// The subscription has been saved, it'll be restored on the next run
+		return nil
+	}
+
+	cb := &pubCallback{secret: secret, leaseEnd: leaseEnd}
+
 	s.locker.Lock()
-	s.callbacks[callbackURL] = &pubCallback{
-		secret: secret,
-		timer: time.AfterFunc(lease, func() {
-			p.unregister(topicURL, callbackURL)
-		}),
+	if old, ok := s.callbacks[callbackURL]; ok {
+		// The subscription is renewed
+		old.timer.Stop()
 	}
+	s.callbacks[callbackURL] = cb
+	cb.timer = p.Clock.AfterFunc(leaseEnd.Sub(p.Clock.Now()), func() {
+		if err := p.unregisterCallback(topicURL, callbackURL, cb); err != nil {
+			p.logger().Error("cannot expire subscription", "topic", topicURL, "callback", callbackURL, "err", err)
+		}
+	})
 	s.locker.Unlock()
 
 	if p.SubscriptionState != nil {
@@ -170,6 +363,12 @@ func (p *Publisher) Register(topicURL, callbackURL, secret string, leaseEnd time
 }
 
 func (p *Publisher) unregister(topicURL, callbackURL string) error {
+	return p.unregisterCallback(topicURL, callbackURL, nil)
+}
+
+// unregisterCallback removes a callback. If cb isn't nil, the callback is only
+// removed if it hasn't been renewed since.
+func (p *Publisher) unregisterCallback(topicURL, callbackURL string, cb *pubCallback) error {
 	p.locker.Lock()
 	s, ok := p.subscriptions[topicURL]
 	p.locker.Unlock()
@@ -181,20 +380,24 @@ func (p *Publisher) unregister(topicURL, callbackURL string) error {
 	defer s.locker.Unlock()
 
 	c, ok := s.callbacks[callbackURL]
-	if !ok {
+	if !ok || (cb != nil && c != cb) {
 		return nil
 	}
 
-	if !c.timer.Stop() {
-		<-c.timer.C
-	}
+	c.timer.Stop()
 
 	delete(s.callbacks, callbackURL)
-	if len(s.callbacks) == 0 {
+	if err := p.store.Delete(topicURL, callbackURL); err != nil {
+		return err
+	}
+	if len(s.callbacks) == 0 && !s.unsubscribed {
+		s.unsubscribed = true
 		if err := p.be.Unsubscribe(s.notifies); err != nil {
 			return err
 		}
+		p.locker.Lock()
 		delete(p.subscriptions, topicURL)
+		p.locker.Unlock()
 	}
 
 	if p.SubscriptionState != nil {
@@ -212,7 +415,11 @@ func (p *Publisher) verify(u *url.URL, q url.Values) error {
 	q.Set("hub.challenge", challenge)
 
 	u.RawQuery = q.Encode()
-	subResp, err := p.c.Get(u.String())
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	subResp, err := p.c.Do(req)
 	if err != nil {
 		return err
 	}
@@ -232,11 +439,16 @@ func (p *Publisher) verify(u *url.URL, q url.Values) error {
 	return nil
 }
 
-func (p *Publisher) denied(u *url.URL, q url.Values, deniedErr DeniedError) error {
+func (p *Publisher) denied(u *url.URL, q url.Values, topicURL string, deniedErr DeniedError) error {
 	q.Set("hub.mode", "denied")
+	q.Set("hub.topic", topicURL)
 	q.Set("hub.reason", string(deniedErr))
 	u.RawQuery = q.Encode()
-	resp, err := p.c.Get(u.String())
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.c.Do(req)
 	if err != nil {
 		return err
 	}
@@ -244,8 +456,35 @@ func (p *Publisher) denied(u *url.URL, q url.Values, deniedErr DeniedError) erro
 	return nil
 }
 
-// Subscribe processes a subscribe request.
+// clampLease returns the lease granted to a subscriber that requested lease.
+func (p *Publisher) clampLease(lease time.Duration) time.Duration {
+	if lease <= 0 {
+		lease = DefaultLease
+	}
+	if p.MinLease > 0 && lease < p.MinLease {
+		lease = p.MinLease
+	}
+	if p.MaxLease > 0 && lease > p.MaxLease {
+		lease = p.MaxLease
+	}
+	return lease
+}
+
+// Subscribe processes a subscribe request. The requested lease is clamped
+// between MinLease and MaxLease. If the callback is already subscribed to the
+// topic, its subscription is renewed.
 func (p *Publisher) Subscribe(topicURL, callbackURL, secret string, lease time.Duration) error {
+	if p.isClosed() {
+		return ErrShutdown
+	}
+	if secret != "" {
+		if err := checkSignatureAlgorithm(p.SignatureAlgorithm); err != nil {
+			return err
+		}
+	}
+
+	lease = p.clampLease(lease)
+
 	u, err := url.Parse(callbackURL)
 	if err != nil {
 		return err
@@ -256,7 +495,7 @@ func (p *Publisher) Subscribe(topicURL, callbackURL, secret string, lease time.D
 	s, err := p.subscribeIfNotExist(topicURL)
 	if deniedErr, ok := err.(DeniedError); ok {
 		// Send denied notification
-		return p.denied(u, q, deniedErr)
+		return p.denied(u, q, topicURL, deniedErr)
 	} else if err != nil {
 		return err
 	}
@@ -269,24 +508,15 @@ func (p *Publisher) Subscribe(topicURL, callbackURL, secret string, lease time.D
 		return err
 	}
 
-	s.locker.Lock()
-	s.callbacks[callbackURL] = &pubCallback{
-		secret: secret,
-		timer: time.AfterFunc(lease, func() {
-			p.unregister(topicURL, callbackURL)
-		}),
-	}
-	s.locker.Unlock()
-
-	if p.SubscriptionState != nil {
-		p.SubscriptionState(topicURL, callbackURL, secret, time.Now().Add(lease))
-	}
-
-	return nil
+	return p.register(s, topicURL, callbackURL, secret, p.Clock.Now().Add(lease))
 }
 
 // Unsubscribe processes an unsubscribe request.
 func (p *Publisher) Unsubscribe(topicURL, callbackURL string) error {
+	if p.isClosed() {
+		return ErrShutdown
+	}
+
 	u, err := url.Parse(callbackURL)
 	if err != nil {
 		return err
@@ -327,6 +557,11 @@ func (p *Publisher) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	}
 
 	mode := req.FormValue("hub.mode")
+	if mode == "publish" {
+		p.servePublish(resp, req)
+		return
+	}
+
 	callbackURL := req.FormValue("hub.callback")
 	topicURL := req.FormValue("hub.topic")
 	secret := req.FormValue("hub.secret")
@@ -343,8 +578,26 @@ func (p *Publisher) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 		http.Error(resp, "Secret too long", http.StatusBadRequest)
 		return
 	}
+	if mode == "subscribe" && secret != "" {
+		if err := checkSignatureAlgorithm(p.SignatureAlgorithm); err != nil {
+			p.logger().Error("cannot accept subscription with a secret", "topic", topicURL, "callback", callbackURL, "err", err)
+			http.Error(resp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
+	if !p.acquire() {
+		http.Error(resp, "Shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if p.WebSub && p.HubURL == "" {
+		p.locker.Lock()
+		p.requestHubURL = requestURL(req)
+		p.locker.Unlock()
+	}
 	go func() {
+		defer p.wg.Done()
+
 		var err error
 		switch mode {
 		case "subscribe":
@@ -353,9 +606,60 @@ func (p *Publisher) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 			err = p.Unsubscribe(topicURL, callbackURL)
 		}
 		if err != nil {
-			log.Println("pubsubhubbub: cannot %v to %q with callback %q: %v", mode, topicURL, callbackURL, err)
+			p.logger().Warn("subscription request failed", "mode", mode, "topic", topicURL, "callback", callbackURL, "err", err)
 		}
 	}()
 
 	resp.WriteHeader(http.StatusAccepted)
 }
+
+// hubURL returns the URL of the hub advertised to subscribers, or an empty
+// string if it isn't known yet.
+func (p *Publisher) hubURL() string {
+	if p.HubURL != "" {
+		return p.HubURL
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.requestHubURL
+}
+
+// requestURL returns the absolute URL of a request, without its query.
+func requestURL(req *http.Request) string {
+	u := url.URL{Scheme: "http", Host: req.Host, Path: req.URL.Path}
+	if req.TLS != nil {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+func (p *Publisher) servePublish(resp http.ResponseWriter, req *http.Request) {
+	be, ok := p.be.(PublishBackend)
+	if !ok {
+		http.Error(resp, "Invalid mode", http.StatusBadRequest)
+		return
+	}
+
+	topics := req.Form["hub.url"]
+	if len(topics) == 0 {
+		http.Error(resp, "Missing topic URL", http.StatusBadRequest)
+		return
+	}
+
+	if !p.acquire() {
+		http.Error(resp, "Shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	go func() {
+		defer p.wg.Done()
+
+		for _, topicURL := range topics {
+			if err := be.Publish(topicURL); err != nil {
+				p.logger().Error("cannot publish topic", "topic", topicURL, "err", err)
+			}
+		}
+	}()
+
+	resp.WriteHeader(http.StatusNoContent)
+}
@@ -3,7 +3,12 @@
 package pubsubhubbub
 
 import (
+	"errors"
 	"io"
+	"mime"
+	"sync"
+
+	"github.com/emersion/go-ostatus/activitystream"
 )
 
 const (
@@ -25,3 +30,50 @@ type Event interface {
 
 // ReadEventFunc reads an event.
 type ReadEventFunc func(mediaType string, body io.Reader) (Event, error)
+
+var (
+	eventFormatsLocker sync.RWMutex
+	eventFormats       = map[string]ReadEventFunc{
+		"application/atom+xml": readAtomFeed,
+	}
+)
+
+func readAtomFeed(mediaType string, body io.Reader) (Event, error) {
+	feed, err := activitystream.Read(body)
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// RegisterEventFormat registers a function ReadEvent uses to read events of the
+// given media type. It replaces any function previously registered for this
+// media type.
+//
+// Atom is supported by default. Packages implementing other formats register
+// them when imported, for instance:
+//
+//	import _ "github.com/emersion/go-ostatus/rss"
+func RegisterEventFormat(mediaType string, f ReadEventFunc) {
+	eventFormatsLocker.Lock()
+	defer eventFormatsLocker.Unlock()
+
+	eventFormats[mediaType] = f
+}
+
+// ReadEvent is a ReadEventFunc that picks a function registered with
+// RegisterEventFormat depending on the media type. Media type parameters are
+// ignored.
+func ReadEvent(mediaType string, body io.Reader) (Event, error) {
+	if mt, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = mt
+	}
+
+	eventFormatsLocker.RLock()
+	f, ok := eventFormats[mediaType]
+	eventFormatsLocker.RUnlock()
+	if !ok {
+		return nil, errors.New("pubsubhubbub: unsupported notification media type")
+	}
+	return f(mediaType, body)
+}
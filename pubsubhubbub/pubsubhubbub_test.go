@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 
 type dummyBackend struct {
 	topics map[string]chan<- Event
+	locker sync.Mutex
 }
 
 func newDummyBackend() *dummyBackend {
@@ -23,11 +25,17 @@ func newDummyBackend() *dummyBackend {
 }
 
 func (be *dummyBackend) Subscribe(topic string, notifies chan<- Event) error {
+	be.locker.Lock()
+	defer be.locker.Unlock()
+
 	be.topics[topic] = notifies
 	return nil
 }
 
 func (be *dummyBackend) Unsubscribe(notifies chan<- Event) error {
+	be.locker.Lock()
+	defer be.locker.Unlock()
+
 	for topic, ch := range be.topics {
 		if notifies == ch {
 			delete(be.topics, topic)
@@ -38,6 +46,15 @@ func (be *dummyBackend) Unsubscribe(notifies chan<- Event) error {
 	return nil
 }
 
+// notifies returns the channel for a topic, or nil if the publisher isn't
+// subscribed to it.
+func (be *dummyBackend) notifies(topic string) chan<- Event {
+	be.locker.Lock()
+	defer be.locker.Unlock()
+
+	return be.topics[topic]
+}
+
 type emptyReadCloser struct{}
 
 func (r *emptyReadCloser) Read(b []byte) (int, error) {
@@ -62,6 +79,13 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return w.Result(), nil
 }
 
+func readAtomEvent(mediaType string, body io.Reader) (Event, error) {
+	if mediaType != "application/atom+xml" {
+		return nil, errors.New("pubsubhubbub: unsupported notification media type")
+	}
+	return activitystream.Read(body)
+}
+
 func Test(t *testing.T) {
 	subscriberURL := "http://localhost/subscriber"
 	publisherURL := "http://localhost/publisher"
@@ -70,12 +94,7 @@ func Test(t *testing.T) {
 
 	be := newDummyBackend()
 	pub := NewPublisher(be)
-	sub := NewSubscriber(subscriberURL + "/webhook", func(mediaType string, body io.Reader) (Event, error) {
-		if mediaType != "application/atom+xml" {
-			return nil, errors.New("pubsubhubbub: unsupported notification media type")
-		}
-		return activitystream.Read(body)
-	})
+	sub := NewSubscriber(subscriberURL+"/webhook", readAtomEvent)
 	pub.c.Transport = &roundTripper{sub}
 	sub.c.Transport = &roundTripper{pub}
 
@@ -116,13 +135,13 @@ func Test(t *testing.T) {
 	}
 
 	// Send notification
-	be.topics[topicURL] <- sent
+	be.notifies(topicURL) <- sent
 
 	// Receive notification
 	received := (<-notifies).(*activitystream.Feed)
 
 	sent.XMLName = received.XMLName
 	if !reflect.DeepEqual(sent, received) {
-		t.Error("Invalid notification, expected \n%+v\n but got \n%+v", sent, received)
+		t.Errorf("Invalid notification, expected \n%+v\n but got \n%+v", sent, received)
 	}
 }
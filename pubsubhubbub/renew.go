@@ -0,0 +1,137 @@
+package pubsubhubbub
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Default Subscriber lease renewal parameters.
+var (
+	// DefaultRenewFraction is the default fraction of a lease after which a
+	// subscription is renewed.
+	DefaultRenewFraction = 0.8
+	// DefaultRetryDelay is the default delay before retrying a failed renewal.
+	DefaultRetryDelay = time.Minute
+)
+
+// ErrLeaseExpired is passed to Subscriber.SubscriptionLost when a lease expires
+// before the subscription could be renewed.
+var ErrLeaseExpired = errors.New("pubsubhubbub: lease expired")
+
+// renewalDelay returns the delay before renewing a subscription with the
+// provided lease.
+func (s *Subscriber) renewalDelay(lease time.Duration) time.Duration {
+	f := s.RenewFraction
+	if f <= 0 || f >= 1 {
+		// Never renew in a busy loop nor after the lease has expired
+		f = DefaultRenewFraction
+	}
+	return time.Duration(float64(lease) * f)
+}
+
+// retryDelay returns the delay before the next renewal attempt, after n
+// attempts.
+func (s *Subscriber) retryDelay(n int) time.Duration {
+	d := s.RetryDelay
+	if d <= 0 {
+		// Never retry in a busy loop
+		d = DefaultRetryDelay
+	}
+	for i := 1; i < n && d < time.Hour; i++ {
+		d *= 2
+	}
+	return d
+}
+
+// scheduleRenewal schedules a subscription renewal. It must be called with
+// s.locker held.
+func (s *Subscriber) scheduleRenewal(key hubTopic, sub *subscription, d time.Duration) {
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	if s.closed {
+		// The subscription is in the store, it'll be renewed on the next run
+		return
+	}
+	sub.timer = time.AfterFunc(d, func() {
+		s.renew(key, sub)
+	})
+}
+
+func (s *Subscriber) renew(key hubTopic, sub *subscription) {
+	topic := key.topic
+
+	if !s.acquire() {
+		return
+	}
+	defer s.wg.Done()
+
+	s.locker.Lock()
+	if s.subscriptions[key] != sub {
+		s.locker.Unlock()
+		return
+	}
+	hub := sub.hub
+	renewals := sub.renewals
+	data := s.subscribeData(topic, sub)
+	in := s.addIntent(sub, "subscribe")
+	s.locker.Unlock()
+
+	err := s.request(s.ctx, hub, data)
+	if err != nil {
+		s.logger().Warn("cannot renew subscription", "hub", hub, "topic", topic, "err", err)
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if err != nil {
+		sub.removeIntent(in)
+	}
+
+	if s.subscriptions[key] != sub || sub.renewals != renewals || s.closed {
+		// The subscription has been removed or renewed in the meantime, or the
+		// subscriber is shutting down
+		return
+	}
+
+	// Retry later, unless the hub confirms the renewal before
+	sub.attempts++
+	if err != nil {
+		sub.lastErr = err
+	}
+
+	delay := s.retryDelay(sub.attempts)
+	if time.Now().Add(delay).Before(sub.lease) {
+		s.scheduleRenewal(key, sub, delay)
+		return
+	}
+
+	sub.timer = time.AfterFunc(time.Until(sub.lease), func() {
+		s.expire(key, sub, renewals)
+	})
+}
+
+func (s *Subscriber) expire(key hubTopic, sub *subscription, renewals int) {
+	topic := key.topic
+
+	s.locker.Lock()
+	if s.subscriptions[key] != sub || sub.renewals != renewals || s.closed {
+		s.locker.Unlock()
+		return
+	}
+
+	err := ErrLeaseExpired
+	if sub.lastErr != nil {
+		err = fmt.Errorf("%w: %v", ErrLeaseExpired, sub.lastErr)
+	}
+
+	s.logger().Warn("lost subscription", "hub", sub.hub, "topic", topic, "err", err)
+	s.remove(key, sub, err)
+	s.locker.Unlock()
+
+	if s.SubscriptionLost != nil {
+		s.SubscriptionLost(sub.hub, topic, err)
+	}
+}
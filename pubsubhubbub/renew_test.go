@@ -0,0 +1,163 @@
+package pubsubhubbub
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHub is a minimal hub that confirms subscriptions with a fixed lease.
+type testHub struct {
+	sub   *Subscriber
+	lease string
+	// fail is called for each request, and the request is rejected if it
+	// returns true
+	fail func(n int) bool
+
+	locker   sync.Mutex
+	requests []url.Values
+}
+
+func (h *testHub) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+
+	h.locker.Lock()
+	h.requests = append(h.requests, req.PostForm)
+	n := len(h.requests)
+	h.locker.Unlock()
+
+	if h.fail != nil && h.fail(n) {
+		http.Error(resp, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	u, _ := url.Parse(req.PostForm.Get("hub.callback"))
+	q := u.Query()
+	q.Set("hub.mode", req.PostForm.Get("hub.mode"))
+	q.Set("hub.topic", req.PostForm.Get("hub.topic"))
+	q.Set("hub.challenge", "challenge")
+	q.Set("hub.lease_seconds", h.lease)
+	u.RawQuery = q.Encode()
+
+	go func() {
+		w := httptest.NewRecorder()
+		h.sub.ServeHTTP(w, httptest.NewRequest(http.MethodGet, u.String(), nil))
+	}()
+
+	resp.WriteHeader(http.StatusAccepted)
+}
+
+func (h *testHub) numRequests() int {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	return len(h.requests)
+}
+
+func TestSubscriber_renew(t *testing.T) {
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	sub.Lease = time.Second
+	sub.RenewFraction = 0.5
+	hub := &testHub{sub: sub, lease: "1"}
+	sub.c.Transport = &roundTripper{hub}
+
+	if err := sub.Subscribe("http://localhost/hub", "http://localhost/topic.atom", make(chan Event)); err != nil {
+		t.Fatal("Expected no error when subscribing, got:", err)
+	}
+
+	hub.locker.Lock()
+	lease := hub.requests[0].Get("hub.lease_seconds")
+	hub.locker.Unlock()
+	if lease != "1" {
+		t.Errorf("Invalid requested lease: expected %v but got %q", 1, lease)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.numRequests() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := hub.numRequests(); n < 3 {
+		t.Errorf("Expected the subscription to be renewed twice, got %v requests", n)
+	}
+}
+
+func TestSubscriber_renewFailed(t *testing.T) {
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	sub.RenewFraction = 0.5
+	sub.RetryDelay = 100 * time.Millisecond
+	hub := &testHub{
+		sub:   sub,
+		lease: "1",
+		fail: func(n int) bool {
+			return n > 1
+		},
+	}
+	sub.c.Transport = &roundTripper{hub}
+
+	type lost struct {
+		hub, topic string
+		err        error
+	}
+	losts := make(chan lost, 1)
+	sub.SubscriptionLost = func(hub, topic string, err error) {
+		losts <- lost{hub, topic, err}
+	}
+
+	notifies := make(chan Event)
+	if err := sub.Subscribe("http://localhost/hub", "http://localhost/topic.atom", notifies); err != nil {
+		t.Fatal("Expected no error when subscribing, got:", err)
+	}
+
+	select {
+	case l := <-losts:
+		if l.hub != "http://localhost/hub" || l.topic != "http://localhost/topic.atom" {
+			t.Errorf("Invalid lost subscription: %v %v", l.hub, l.topic)
+		}
+		if !errors.Is(l.err, ErrLeaseExpired) {
+			t.Errorf("Expected ErrLeaseExpired, got %v", l.err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Subscription not lost")
+	}
+
+	if n := hub.numRequests(); n < 3 {
+		t.Errorf("Expected renewal to be retried, got %v requests", n)
+	}
+	if _, ok := <-notifies; ok {
+		t.Error("Expected notifies to be closed")
+	}
+}
+
+func TestSubscriber_renewalDelay(t *testing.T) {
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	want := time.Duration(float64(time.Hour) * DefaultRenewFraction)
+	for _, f := range []float64{0, -0.5, 1, 2} {
+		sub.RenewFraction = f
+		if d := sub.renewalDelay(time.Hour); d != want {
+			t.Errorf("renewalDelay(RenewFraction = %v) = %v, want %v", f, d, want)
+		}
+	}
+
+	sub.RenewFraction = 0.5
+	if d := sub.renewalDelay(time.Hour); d != 30*time.Minute {
+		t.Errorf("renewalDelay() = %v, want %v", d, 30*time.Minute)
+	}
+}
+
+func TestSubscriber_retryDelay(t *testing.T) {
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	for _, retryDelay := range []time.Duration{0, -time.Second} {
+		sub.RetryDelay = retryDelay
+		if d := sub.retryDelay(1); d != DefaultRetryDelay {
+			t.Errorf("retryDelay(RetryDelay = %v) = %v, want %v", retryDelay, d, DefaultRetryDelay)
+		}
+	}
+
+	sub.RetryDelay = time.Second
+	if d := sub.retryDelay(3); d != 4*time.Second {
+		t.Errorf("retryDelay(3) = %v, want %v", d, 4*time.Second)
+	}
+}
@@ -0,0 +1,147 @@
+package pubsubhubbub
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrShutdown is returned when a Publisher or a Subscriber is used after a
+// call to Shutdown.
+var ErrShutdown = errors.New("pubsubhubbub: shut down")
+
+// acquire registers in-flight work that Shutdown waits for. It returns false
+// if the publisher is shutting down. Otherwise p.wg.Done must be called when
+// the work is done.
+func (p *Publisher) acquire() bool {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if p.closed {
+		return false
+	}
+	p.wg.Add(1)
+	return true
+}
+
+func (p *Publisher) isClosed() bool {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.closed
+}
+
+// Shutdown stops the publisher. New requests are rejected, lease timers and
+// delivery retries are stopped, and the backend is unsubscribed from all
+// topics. Shutdown then waits for in-flight verifications and deliveries until
+// ctx is done, after which they're canceled and ctx.Err() is returned.
+//
+// Subscriptions and pending deliveries are left in their stores, so that they
+// can be restored by NewPublisherWithStore and ResumeDeliveries.
+func (p *Publisher) Shutdown(ctx context.Context) error {
+	p.locker.Lock()
+	if p.closed {
+		p.locker.Unlock()
+		return ErrShutdown
+	}
+	p.closed = true
+	close(p.done)
+	for id, t := range p.retries {
+		t.Stop()
+		delete(p.retries, id)
+	}
+	subscriptions := make([]*pubSubscription, 0, len(p.subscriptions))
+	for _, s := range p.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	p.locker.Unlock()
+
+	var err error
+	for _, s := range subscriptions {
+		s.locker.Lock()
+		for _, cb := range s.callbacks {
+			cb.timer.Stop()
+		}
+		unsubscribe := !s.unsubscribed
+		s.unsubscribed = true
+		s.locker.Unlock()
+
+		if unsubscribe {
+			if unsubscribeErr := p.be.Unsubscribe(s.notifies); unsubscribeErr != nil && err == nil {
+				err = unsubscribeErr
+			}
+		}
+	}
+
+	return waitShutdown(ctx, p.wg.Wait, p.cancel, err)
+}
+
+// waitShutdown waits for wait to return until ctx is done. cancel is then
+// called to release resources, or to cancel in-flight work if ctx is done
+// first.
+func waitShutdown(ctx context.Context, wait func(), cancel context.CancelFunc, err error) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		cancel()
+		return err
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
+}
+
+// acquire is like Publisher.acquire.
+func (s *Subscriber) acquire() bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+// withShutdown returns a copy of ctx that is canceled with ErrShutdown when
+// Shutdown gives up waiting.
+func (s *Subscriber) withShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(s.ctx, func() {
+		cancel(ErrShutdown)
+	})
+	return ctx, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
+
+// Shutdown stops the subscriber. New calls to Subscribe and Unsubscribe fail
+// with ErrShutdown, notifications are rejected so that hubs retry later, and
+// lease renewals are stopped. Shutdown then waits for pending Subscribe and
+// Unsubscribe calls and notifications being delivered until ctx is done, after
+// which they fail with ErrShutdown and ctx.Err() is returned.
+//
+// Hubs keep verification requests for pending calls working while Shutdown
+// waits. Subscriptions are not removed from hubs nor from the store, and
+// notification channels are not closed, so that NewSubscriberWithStore can
+// restore them.
+func (s *Subscriber) Shutdown(ctx context.Context) error {
+	s.locker.Lock()
+	if s.closed {
+		s.locker.Unlock()
+		return ErrShutdown
+	}
+	s.closed = true
+	for _, sub := range s.subscriptions {
+		if sub.timer != nil {
+			sub.timer.Stop()
+		}
+	}
+	s.locker.Unlock()
+
+	return waitShutdown(ctx, s.wg.Wait, s.cancel, nil)
+}
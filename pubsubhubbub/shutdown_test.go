@@ -0,0 +1,158 @@
+package pubsubhubbub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublisher_Shutdown(t *testing.T) {
+	topicURL := "http://localhost/publisher/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook"
+
+	cb := newTestCallback(1000, http.StatusServiceUnavailable)
+	pub, be := testPublisher(t, cb)
+	pub.RetryDelay = time.Hour
+
+	if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal("Register() =", err)
+	}
+
+	be.notifies(topicURL) <- testFeed(topicURL)
+
+	// Wait for the delivery to be scheduled for a retry
+	deadline := time.Now().Add(time.Second)
+	for {
+		if l, _ := pub.Deliveries.List(); len(l) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Delivery not retried")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := pub.Shutdown(context.Background()); err != nil {
+		t.Fatal("Shutdown() =", err)
+	}
+
+	if be.notifies(topicURL) != nil {
+		t.Error("Backend still subscribed after shutdown")
+	}
+	if l, _ := pub.Deliveries.List(); len(l) != 1 {
+		t.Errorf("Expected the pending delivery to be kept, got %v", len(l))
+	}
+	if l, _ := pub.store.List(); len(l) != 1 {
+		t.Errorf("Expected the subscription to be kept, got %v", len(l))
+	}
+
+	if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != ErrShutdown {
+		t.Errorf("Register() after shutdown = %v, want %v", err, ErrShutdown)
+	}
+
+	w := httptest.NewRecorder()
+	form := url.Values{
+		"hub.mode":     {"subscribe"},
+		"hub.topic":    {topicURL},
+		"hub.callback": {callbackURL},
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/publisher/hub", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	pub.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after shutdown, got %v", w.Code)
+	}
+}
+
+func TestPublisher_Shutdown_deadline(t *testing.T) {
+	topicURL := "http://localhost/publisher/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook"
+
+	pub, be := testPublisher(t, nil)
+	// This callback never replies
+	pub.c.Transport = hangingTransport{}
+
+	if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal("Register() =", err)
+	}
+
+	be.notifies(topicURL) <- testFeed(topicURL)
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pub.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The canceled delivery is saved for the next run
+	deadline := time.Now().Add(time.Second)
+	for {
+		if l, _ := pub.Deliveries.List(); len(l) == 1 {
+			if l[0].Attempts != 0 {
+				t.Errorf("Expected a canceled delivery not to count as an attempt")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Canceled delivery not saved")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubscriber_Shutdown(t *testing.T) {
+	hubURL := "http://localhost/hub"
+	topicURL := "http://localhost/topic.atom"
+
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	// This hub accepts requests but never verifies them
+	sub.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusAccepted)
+	})}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sub.Subscribe(hubURL, topicURL, make(chan Event))
+	}()
+
+	// Wait for the subscription to be pending
+	var callbackURL, secret string
+	for callbackURL == "" {
+		sub.locker.Lock()
+		if s, ok := sub.subscriptions[hubTopic{hubURL, topicURL}]; ok {
+			callbackURL, secret = s.callbackURL, s.secret
+		}
+		sub.locker.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := sub.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrShutdown) {
+			t.Errorf("Pending Subscribe() = %v, want %v", err, ErrShutdown)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pending Subscribe still blocked after shutdown")
+	}
+
+	if err := sub.Subscribe(hubURL, topicURL, make(chan Event)); err != ErrShutdown {
+		t.Errorf("Subscribe() after shutdown = %v, want %v", err, ErrShutdown)
+	}
+
+	w := postNotification(context.Background(), sub, callbackURL, secret, testFeed(topicURL))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after shutdown, got %v", w.Code)
+	}
+}
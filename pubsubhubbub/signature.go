@@ -0,0 +1,83 @@
+package pubsubhubbub
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Signature algorithms for content distribution.
+const (
+	SignatureSHA1   = "sha1"
+	SignatureSHA256 = "sha256"
+	SignatureSHA384 = "sha384"
+	SignatureSHA512 = "sha512"
+)
+
+// DefaultSignatureAlgorithm is the default algorithm used by publishers to sign
+// content distribution requests.
+var DefaultSignatureAlgorithm = SignatureSHA1
+
+var errUnsupportedSignature = errors.New("pubsubhubbub: unsupported signature algorithm")
+
+func signatureHash(alg string) func() hash.Hash {
+	switch alg {
+	case SignatureSHA1:
+		return sha1.New
+	case SignatureSHA256:
+		return sha256.New
+	case SignatureSHA384:
+		return sha512.New384
+	case SignatureSHA512:
+		return sha512.New
+	}
+	return nil
+}
+
+// checkSignatureAlgorithm checks that alg can be used to sign content
+// distribution requests.
+func checkSignatureAlgorithm(alg string) error {
+	if signatureHash(alg) == nil {
+		return fmt.Errorf("%w %q", errUnsupportedSignature, alg)
+	}
+	return nil
+}
+
+// sign returns the X-Hub-Signature header value for body.
+func sign(alg, secret string, body []byte) (string, error) {
+	newHash := signatureHash(alg)
+	if newHash == nil {
+		return "", errUnsupportedSignature
+	}
+
+	h := hmac.New(newHash, []byte(secret))
+	h.Write(body)
+	return alg + "=" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// parseSignature parses a X-Hub-Signature header value. It returns a HMAC
+// ready to be written to and the expected MAC.
+func parseSignature(v, secret string) (hash.Hash, []byte, error) {
+	alg, sig, ok := strings.Cut(v, "=")
+	if !ok {
+		return nil, nil, errors.New("pubsubhubbub: malformed signature")
+	}
+
+	newHash := signatureHash(alg)
+	if newHash == nil {
+		return nil, nil, errUnsupportedSignature
+	}
+
+	mac, err := hex.DecodeString(sig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return hmac.New(newHash, []byte(secret)), mac, nil
+}
@@ -0,0 +1,164 @@
+package pubsubhubbub
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	for _, alg := range []string{SignatureSHA1, SignatureSHA256, SignatureSHA384, SignatureSHA512} {
+		sig, err := sign(alg, "secret", []byte("body"))
+		if err != nil {
+			t.Errorf("sign(%v) = %v", alg, err)
+			continue
+		}
+		if !strings.HasPrefix(sig, alg+"=") {
+			t.Errorf("sign(%v) = %v, want the algorithm as prefix", alg, sig)
+		}
+
+		h, mac, err := parseSignature(sig, "secret")
+		if err != nil {
+			t.Errorf("parseSignature(%v) = %v", sig, err)
+			continue
+		}
+		h.Write([]byte("body"))
+		if !bytes.Equal(h.Sum(nil), mac) {
+			t.Errorf("parseSignature(%v): signature mismatch", sig)
+		}
+	}
+
+	if _, err := sign("md5", "secret", []byte("body")); err != errUnsupportedSignature {
+		t.Errorf("sign(md5) = %v, want %v", err, errUnsupportedSignature)
+	}
+}
+
+func TestPublisher_SignatureAlgorithm(t *testing.T) {
+	hubURL := "http://localhost/publisher/hub"
+	topicURL := "http://localhost/publisher/topic.atom"
+
+	for _, alg := range []string{SignatureSHA1, SignatureSHA256, SignatureSHA384, SignatureSHA512} {
+		be := newDummyBackend()
+		pub := NewPublisher(be)
+		pub.SignatureAlgorithm = alg
+		sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+		pub.c.Transport = &roundTripper{sub}
+		sub.c.Transport = &roundTripper{pub}
+
+		notifies := make(chan Event, 1)
+		if err := sub.Subscribe(hubURL, topicURL, notifies); err != nil {
+			t.Fatalf("%v: Subscribe() = %v", alg, err)
+		}
+
+		be.notifies(topicURL) <- testFeed(topicURL)
+
+		select {
+		case <-notifies:
+		case <-time.After(time.Second):
+			t.Errorf("%v: notification not received", alg)
+		}
+	}
+}
+
+func TestPublisher_unsupportedSignatureAlgorithm(t *testing.T) {
+	topicURL := "http://localhost/publisher/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook"
+
+	pub := NewPublisher(newDummyBackend())
+	pub.SignatureAlgorithm = "md5"
+
+	err := pub.Register(topicURL, callbackURL, "secret", time.Now().Add(time.Hour))
+	if !errors.Is(err, errUnsupportedSignature) || !strings.Contains(err.Error(), "md5") {
+		t.Errorf("Register() = %v, want an unsupported algorithm error", err)
+	}
+	if err := pub.Subscribe(topicURL, callbackURL, "secret", time.Hour); !errors.Is(err, errUnsupportedSignature) {
+		t.Errorf("Subscribe() = %v, want %v", err, errUnsupportedSignature)
+	}
+	if err := pub.Register(topicURL, callbackURL, "", time.Now().Add(time.Hour)); err != nil {
+		t.Errorf("Register(no secret) = %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("hub.mode", "subscribe")
+	form.Set("hub.topic", topicURL)
+	form.Set("hub.callback", callbackURL)
+	form.Set("hub.secret", "secret")
+	req := httptest.NewRequest(http.MethodPost, "/hub", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	pub.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "md5") {
+		t.Errorf("ServeHTTP() = %v %v, want an unsupported algorithm error", w.Code, w.Body.String())
+	}
+}
+
+func TestSubscriber_signature(t *testing.T) {
+	topicURL := "http://localhost/topic.atom"
+	callbackURL := "http://localhost/subscriber/webhook?id=test"
+
+	store := NewMemoryStore()
+	store.Put(&Subscription{
+		Hub:      "http://localhost/hub",
+		Topic:    topicURL,
+		Callback: callbackURL,
+		Secret:   "secret",
+		LeaseEnd: time.Now().Add(time.Hour),
+	})
+	sub, err := NewSubscriberWithStore("http://localhost/subscriber/webhook", readAtomEvent, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifies := make(chan Event, 1)
+	if err := sub.Attach(topicURL, notifies); err != nil {
+		t.Fatal("Attach() =", err)
+	}
+
+	var b bytes.Buffer
+	testFeed(topicURL).WriteTo(&b)
+	body := b.Bytes()
+
+	sha512Sig, _ := sign(SignatureSHA512, "secret", body)
+	wrongSig, _ := sign(SignatureSHA256, "wrong secret", body)
+
+	tests := []struct {
+		sig       string
+		code      int
+		delivered bool
+	}{
+		{sha512Sig, http.StatusOK, true},
+		{wrongSig, http.StatusOK, false},
+		{"", http.StatusOK, false},
+		{"md5=d36733c3ee0c0b2ef7d7fdb3e1d6ac1c", http.StatusBadRequest, false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/atom+xml")
+		if test.sig != "" {
+			req.Header.Set("X-Hub-Signature", test.sig)
+		}
+		w := httptest.NewRecorder()
+		sub.ServeHTTP(w, req)
+
+		if w.Code != test.code {
+			t.Errorf("%q: expected status %v but got %v", test.sig, test.code, w.Code)
+		}
+
+		select {
+		case <-notifies:
+			if !test.delivered {
+				t.Errorf("%q: unexpected notification", test.sig)
+			}
+		default:
+			if test.delivered {
+				t.Errorf("%q: notification not delivered", test.sig)
+			}
+		}
+	}
+}
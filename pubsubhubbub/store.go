@@ -0,0 +1,159 @@
+package pubsubhubbub
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A Subscription is a callback's subscription to a topic. It's used by both
+// publishers and subscribers.
+type Subscription struct {
+	// Hub is the hub URL. It's only set by subscribers.
+	Hub      string    `json:"hub,omitempty"`
+	Topic    string    `json:"topic"`
+	Callback string    `json:"callback"`
+	Secret   string    `json:"secret,omitempty"`
+	LeaseEnd time.Time `json:"lease_end"`
+}
+
+// A SubscriptionStore persists subscriptions.
+type SubscriptionStore interface {
+	// List returns all subscriptions.
+	List() ([]*Subscription, error)
+	// Put creates or updates a subscription.
+	Put(sub *Subscription) error
+	// Delete removes a subscription. It doesn't fail if the subscription
+	// doesn't exist.
+	Delete(topic, callback string) error
+}
+
+type subscriptionKey struct {
+	topic, callback string
+}
+
+type memoryStore struct {
+	subscriptions map[subscriptionKey]*Subscription
+	locker        sync.Mutex
+}
+
+// NewMemoryStore returns a SubscriptionStore that keeps subscriptions in
+// memory.
+func NewMemoryStore() SubscriptionStore {
+	return &memoryStore{
+		subscriptions: make(map[subscriptionKey]*Subscription),
+	}
+}
+
+func (s *memoryStore) List() ([]*Subscription, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	l := make([]*Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		clone := *sub
+		l = append(l, &clone)
+	}
+	return l, nil
+}
+
+func (s *memoryStore) Put(sub *Subscription) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	clone := *sub
+	s.subscriptions[subscriptionKey{sub.Topic, sub.Callback}] = &clone
+	return nil
+}
+
+func (s *memoryStore) Delete(topic, callback string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	delete(s.subscriptions, subscriptionKey{topic, callback})
+	return nil
+}
+
+type fileStore struct {
+	memoryStore
+	path string
+}
+
+// NewFileStore returns a SubscriptionStore that saves subscriptions to a JSON
+// file. The file is rewritten each time a subscription changes.
+func NewFileStore(path string) (SubscriptionStore, error) {
+	s := &fileStore{
+		memoryStore: memoryStore{
+			subscriptions: make(map[subscriptionKey]*Subscription),
+		},
+		path: path,
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var l []*Subscription
+	if err := json.NewDecoder(f).Decode(&l); err != nil {
+		return nil, err
+	}
+	for _, sub := range l {
+		s.subscriptions[subscriptionKey{sub.Topic, sub.Callback}] = sub
+	}
+
+	return s, nil
+}
+
+// save writes subscriptions to the file. It must be called with s.locker held.
+func (s *fileStore) save() error {
+	l := make([]*Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		l = append(l, sub)
+	}
+
+	// Write to a temporary file first, so that the file is never left
+	// half-written
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := json.NewEncoder(f).Encode(l); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path)
+}
+
+func (s *fileStore) Put(sub *Subscription) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	clone := *sub
+	s.subscriptions[subscriptionKey{sub.Topic, sub.Callback}] = &clone
+	return s.save()
+}
+
+func (s *fileStore) Delete(topic, callback string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	k := subscriptionKey{topic, callback}
+	if _, ok := s.subscriptions[k]; !ok {
+		return nil
+	}
+	delete(s.subscriptions, k)
+	return s.save()
+}
@@ -0,0 +1,207 @@
+package pubsubhubbub
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal("NewFileStore() =", err)
+	}
+
+	sub := &Subscription{
+		Topic:    "http://localhost/topic.atom",
+		Callback: "http://localhost/callback",
+		Secret:   "secret",
+		LeaseEnd: time.Now().Add(time.Hour).Round(time.Second),
+	}
+	if err := store.Put(sub); err != nil {
+		t.Fatal("Put() =", err)
+	}
+	if err := store.Put(&Subscription{Topic: sub.Topic, Callback: "http://localhost/other"}); err != nil {
+		t.Fatal("Put() =", err)
+	}
+	if err := store.Delete(sub.Topic, "http://localhost/other"); err != nil {
+		t.Fatal("Delete() =", err)
+	}
+
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal("NewFileStore() =", err)
+	}
+
+	l, err := store.List()
+	if err != nil {
+		t.Fatal("List() =", err)
+	}
+	if len(l) != 1 {
+		t.Fatalf("Expected 1 subscription, got %v", len(l))
+	}
+	if !l[0].LeaseEnd.Equal(sub.LeaseEnd) {
+		t.Errorf("Invalid lease end: expected %v but got %v", sub.LeaseEnd, l[0].LeaseEnd)
+	}
+	l[0].LeaseEnd = sub.LeaseEnd
+	if !reflect.DeepEqual(l[0], sub) {
+		t.Errorf("Invalid subscription: expected \n%+v\n but got \n%+v", sub, l[0])
+	}
+}
+
+func TestNewPublisherWithStore(t *testing.T) {
+	topicURL := "http://localhost/publisher/topic.atom"
+	expiredTopicURL := "http://localhost/publisher/expired.atom"
+	callbackURL := "http://localhost/subscriber/webhook"
+
+	store := NewMemoryStore()
+	store.Put(&Subscription{
+		Topic:    topicURL,
+		Callback: callbackURL,
+		LeaseEnd: time.Now().Add(time.Hour),
+	})
+	store.Put(&Subscription{
+		Topic:    expiredTopicURL,
+		Callback: callbackURL,
+		LeaseEnd: time.Now().Add(-time.Hour),
+	})
+
+	be := newDummyBackend()
+	if _, err := NewPublisherWithStore(be, store, nil); err != nil {
+		t.Fatal("NewPublisherWithStore() =", err)
+	}
+
+	if be.notifies(topicURL) == nil {
+		t.Error("Expected the publisher to subscribe to the restored topic")
+	}
+	if be.notifies(expiredTopicURL) != nil {
+		t.Error("Expected the publisher not to subscribe to the expired topic")
+	}
+
+	l, _ := store.List()
+	if len(l) != 1 || l[0].Topic != topicURL {
+		t.Errorf("Expected the expired subscription to be removed, got %+v", l)
+	}
+}
+
+func TestPublisher_restart(t *testing.T) {
+	subscriberURL := "http://localhost/subscriber"
+	publisherURL := "http://localhost/publisher"
+	hubURL := publisherURL + "/hub"
+	topicURL := publisherURL + "/topic.atom"
+
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal("NewFileStore() =", err)
+	}
+
+	be := newDummyBackend()
+	pub, err := NewPublisherWithStore(be, store, nil)
+	if err != nil {
+		t.Fatal("NewPublisherWithStore() =", err)
+	}
+	sub := NewSubscriber(subscriberURL+"/webhook", readAtomEvent)
+	pub.c.Transport = &roundTripper{sub}
+	sub.c.Transport = &roundTripper{pub}
+
+	notifies := make(chan Event, 1)
+	if err := sub.Subscribe(hubURL, topicURL, notifies); err != nil {
+		t.Fatal("Expected no error when subscribing, got:", err)
+	}
+
+	// Restart the publisher
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal("NewFileStore() =", err)
+	}
+	be = newDummyBackend()
+	pub, err = NewPublisherWithStore(be, store, nil)
+	if err != nil {
+		t.Fatal("NewPublisherWithStore() =", err)
+	}
+	pub.c.Transport = &roundTripper{sub}
+
+	be.notifies(topicURL) <- testFeed(topicURL)
+
+	select {
+	case received := <-notifies:
+		if received.Topic() != topicURL {
+			t.Errorf("Invalid notification topic: expected %v but got %v", topicURL, received.Topic())
+		}
+	case <-time.After(time.Second):
+		t.Error("No notification received after restarting the publisher")
+	}
+}
+
+func TestSubscriber_restart(t *testing.T) {
+	subscriberURL := "http://localhost/subscriber"
+	publisherURL := "http://localhost/publisher"
+	hubURL := publisherURL + "/hub"
+	topicURL := publisherURL + "/topic.atom"
+
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal("NewFileStore() =", err)
+	}
+
+	be := newDummyBackend()
+	pub := NewPublisher(be)
+	sub, err := NewSubscriberWithStore(subscriberURL+"/webhook", readAtomEvent, store)
+	if err != nil {
+		t.Fatal("NewSubscriberWithStore() =", err)
+	}
+	pub.c.Transport = &roundTripper{sub}
+	sub.c.Transport = &roundTripper{pub}
+
+	if err := sub.Subscribe(hubURL, topicURL, make(chan Event)); err != nil {
+		t.Fatal("Expected no error when subscribing, got:", err)
+	}
+
+	l, _ := store.List()
+	if len(l) != 1 || l[0].Hub != hubURL || l[0].Topic != topicURL || l[0].Secret == "" {
+		t.Fatalf("Invalid stored subscriptions: %+v", l)
+	}
+
+	// Restart the subscriber
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal("NewFileStore() =", err)
+	}
+	sub, err = NewSubscriberWithStore(subscriberURL+"/webhook", readAtomEvent, store)
+	if err != nil {
+		t.Fatal("NewSubscriberWithStore() =", err)
+	}
+
+	notify := func() int {
+		w := postNotification(context.Background(), sub, l[0].Callback, l[0].Secret, testFeed(topicURL))
+		return w.Code
+	}
+
+	if code := notify(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected notification to be rejected before Attach, got %v", code)
+	}
+
+	notifies := make(chan Event, 1)
+	if err := sub.Attach(topicURL, notifies); err != nil {
+		t.Fatal("Attach() =", err)
+	}
+
+	if code := notify(); code/100 != 2 {
+		t.Errorf("Expected notification to be accepted after Attach, got %v", code)
+	}
+	select {
+	case received := <-notifies:
+		if received.Topic() != topicURL {
+			t.Errorf("Invalid notification topic: expected %v but got %v", topicURL, received.Topic())
+		}
+	default:
+		t.Error("No notification received after restarting the subscriber")
+	}
+}
@@ -1,10 +1,11 @@
 package pubsubhubbub
 
 import (
+	"context"
 	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/hex"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"hash"
 	"io"
 	"io/ioutil"
@@ -12,9 +13,10 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"log"
+	"log/slog"
 )
 
 // An HTTPError is an HTTP error. Its value is the HTTP status code.
@@ -34,41 +36,323 @@ func (err DeniedError) Error() string {
 	return "pubsubhubbub: subscription denied: " + string(err)
 }
 
+// ErrVerificationTimeout is returned when a hub doesn't verify a request
+// before the context passed to SubscribeContext or UnsubscribeContext expires.
+var ErrVerificationTimeout = errors.New("pubsubhubbub: hub verification timed out")
+
+// A SubscriptionError is returned by SubscribeContext and UnsubscribeContext
+// when a subscription or unsubscription request fails.
+type SubscriptionError struct {
+	// Mode is either "subscribe" or "unsubscribe".
+	Mode  string
+	Hub   string
+	Topic string
+	// Err is a HTTPError if the hub rejected the request, a DeniedError if the
+	// publisher denied it, ErrVerificationTimeout if the hub didn't verify it in
+	// time. Other errors are possible too.
+	Err error
+}
+
+// Error implements error.
+func (err *SubscriptionError) Error() string {
+	return fmt.Sprintf("pubsubhubbub: cannot %v to topic %q on hub %q: %v", err.Mode, err.Topic, err.Hub, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *SubscriptionError) Unwrap() error {
+	return err.Err
+}
+
+// verificationError returns the error of a request that hasn't been verified
+// before ctx was done.
+func verificationError(ctx context.Context) error {
+	if context.Cause(ctx) == ErrShutdown {
+		return ErrShutdown
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrVerificationTimeout
+	}
+	return ctx.Err()
+}
+
+// errSubscriptionRemoved is returned by a pending Subscribe call when the
+// subscription is removed before the hub confirms it.
+var errSubscriptionRemoved = errors.New("pubsubhubbub: subscription removed before confirmation")
+
+// dedupWindow is the number of recent notifications remembered per topic to
+// detect duplicates received through different hubs.
+const dedupWindow = 64
+
+// A topicSink delivers notifications about a topic. It's shared by all
+// subscriptions to the topic, one per hub. Its fields are protected by
+// Subscriber.locker.
+type topicSink struct {
+	notifies      chan<- Event
+	subscriptions int
+
+	// deliveries tracks in-flight notifications. Once the last subscription
+	// has been removed, notifies is closed when they're done.
+	deliveries sync.WaitGroup
+
+	// recent contains the last notifications
+	recent []recentNotification
+	next   int
+}
+
+type recentNotification struct {
+	digest [sha256.Size]byte
+	hub    string
+}
+
+// seen checks if a notification has recently been received through another
+// hub. If not, it's remembered.
+func (sink *topicSink) seen(n recentNotification) bool {
+	for _, r := range sink.recent {
+		if r.digest == n.digest && r.hub != n.hub {
+			return true
+		}
+	}
+
+	if len(sink.recent) < dedupWindow {
+		sink.recent = append(sink.recent, n)
+	} else {
+		sink.recent[sink.next] = n
+		sink.next = (sink.next + 1) % dedupWindow
+	}
+	return false
+}
+
+// forget removes a notification from the recent ones, so that it's accepted
+// when a hub retries.
+func (sink *topicSink) forget(n recentNotification) {
+	for i, r := range sink.recent {
+		if r == n {
+			sink.recent[i] = recentNotification{}
+		}
+	}
+}
+
+// A hubTopic identifies a subscription.
+type hubTopic struct {
+	hub, topic string
+}
+
+// A subscription's fields are protected by Subscriber.locker, except id, hub,
+// topic, callbackURL, secret and sink which never change.
 type subscription struct {
+	id           string
+	hub          string
+	topic        string
 	callbackURL  string
 	lease        time.Time
 	secret       string
-	notifies     chan<- Event
+	sink         *topicSink
+	pending      bool
 	subscribes   chan error
 	unsubscribes chan error
+	intents      []*intent
+
+	// Lease renewal state
+	timer    *time.Timer
+	renewals int
+	attempts int
+	lastErr  error
 }
 
-// A Subscriber subscribes to publishers.
+// A Subscriber subscribes to publishers. It's safe to use a Subscriber from
+// multiple goroutines.
 type Subscriber struct {
+	// Lease is the lease duration requested when subscribing. If zero, the hub
+	// picks one.
+	Lease time.Duration
+	// RenewFraction is the fraction of a lease after which the subscription is
+	// renewed. It must be between 0 and 1 exclusive, otherwise
+	// DefaultRenewFraction is used.
+	RenewFraction float64
+	// RetryDelay is the delay before retrying a failed renewal. It doubles
+	// after each attempt. If not positive, DefaultRetryDelay is used.
+	RetryDelay time.Duration
+	// SubscriptionLost specifies an optional callback function that is called
+	// when a subscription ends without a call to Unsubscribe, because its lease
+	// couldn't be renewed or because the hub denied it.
+	SubscriptionLost func(hub, topic string, err error)
+	// IntentTimeout is the duration during which a hub can verify a request.
+	// Unexpected verifications are rejected.
+	IntentTimeout time.Duration
+	// Handler handles notifications about topics subscribed to without a
+	// channel, and about restored subscriptions with no attached channel. If
+	// nil, these notifications are rejected so that the hub retries later.
+	Handler EventHandler
+	// Logger receives verification requests, denials, invalid signatures and
+	// failed renewals. Log records have hub, topic and err attributes where
+	// relevant. If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	// WebSub enables WebSub conformance: hubs can accept requests with any 2xx
+	// status, and content distribution requests for unknown subscriptions are
+	// answered with 410 Gone so that hubs remove them.
+	WebSub bool
+
 	c             *http.Client
 	callbackURL   string
-	subscriptions map[string]*subscription
+	store         SubscriptionStore
+	subscriptions map[hubTopic]*subscription
+	callbacks     map[string]*subscription
+	sinks         map[string]*topicSink
 	readEvent     ReadEventFunc
+	locker        sync.Mutex
+
+	// Shutdown state, see Publisher
+	closed bool
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-// NewSubscriber creates a new subscriber.
+// NewSubscriber creates a new subscriber. Subscriptions are kept in memory.
+//
+// Each subscription gets its own callback URL, built from callbackURL with a
+// random identifier. If callbackURL contains "{id}", it's replaced with the
+// identifier, which must be a path segment. Otherwise the identifier is added
+// to the query string.
 func NewSubscriber(callbackURL string, readEvent ReadEventFunc) *Subscriber {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Subscriber{
+		RenewFraction: DefaultRenewFraction,
+		RetryDelay:    DefaultRetryDelay,
+		IntentTimeout: DefaultIntentTimeout,
+		Logger:        slog.Default(),
 		c:             new(http.Client),
 		callbackURL:   callbackURL,
-		subscriptions: make(map[string]*subscription),
+		store:         NewMemoryStore(),
+		subscriptions: make(map[hubTopic]*subscription),
+		callbacks:     make(map[string]*subscription),
+		sinks:         make(map[string]*topicSink),
 		readEvent:     readEvent,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+func (s *Subscriber) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
 	}
+	return s.Logger
 }
 
-func (s *Subscriber) request(hub string, data url.Values) error {
-	resp, err := s.c.PostForm(hub, data)
+// NewSubscriberWithStore creates a new subscriber that persists subscriptions
+// in store. Subscriptions already in store are restored, expired ones are
+// removed. Notifications for restored subscriptions are passed to Handler, or
+// rejected until a channel is attached with Attach if Handler is nil.
+func NewSubscriberWithStore(callbackURL string, readEvent ReadEventFunc, store SubscriptionStore) (*Subscriber, error) {
+	s := NewSubscriber(callbackURL, readEvent)
+	s.store = store
+
+	l, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, sub := range l {
+		if !sub.LeaseEnd.After(now) {
+			if err := store.Delete(sub.Topic, sub.Callback); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		u, err := url.Parse(sub.Callback)
+		if err != nil {
+			return nil, err
+		}
+		id := s.callbackID(u)
+		if id == "" {
+			return nil, fmt.Errorf("pubsubhubbub: no subscription identifier in callback %q", sub.Callback)
+		}
+
+		s.locker.Lock()
+		restored := &subscription{
+			id:           id,
+			hub:          sub.Hub,
+			topic:        sub.Topic,
+			callbackURL:  sub.Callback,
+			lease:        sub.LeaseEnd,
+			secret:       sub.Secret,
+			sink:         s.sink(sub.Topic),
+			subscribes:   make(chan error, 1),
+			unsubscribes: make(chan error, 1),
+		}
+		s.subscriptions[hubTopic{sub.Hub, sub.Topic}] = restored
+		s.callbacks[id] = restored
+
+		// The initial lease duration is unknown, renew after a fraction of the
+		// remaining time
+		s.scheduleRenewal(hubTopic{sub.Hub, sub.Topic}, restored, s.renewalDelay(sub.LeaseEnd.Sub(now)))
+		s.locker.Unlock()
+	}
+
+	return s, nil
+}
+
+// Attach sets the channel notifications about a restored subscription are sent
+// to. The channel is used for all hubs the topic is subscribed to.
+func (s *Subscriber) Attach(topic string, notifies chan<- Event) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	sink, ok := s.sinks[topic]
+	if !ok {
+		return errors.New("pubsubhubbub: no such subsciption")
+	}
+	if sink.notifies != nil {
+		return errors.New("pubsubhubbub: subscription already attached")
+	}
+
+	sink.notifies = notifies
+	return nil
+}
+
+// sink returns the sink of a topic, creating it if necessary, and adds a
+// reference to it. It must be called with s.locker held.
+func (s *Subscriber) sink(topic string) *topicSink {
+	sink, ok := s.sinks[topic]
+	if !ok {
+		sink = new(topicSink)
+		s.sinks[topic] = sink
+	}
+	sink.subscriptions++
+	return sink
+}
+
+// releaseSink removes a reference to a topic's sink. It returns true if it was
+// the last one. It must be called with s.locker held.
+func (s *Subscriber) releaseSink(topic string, sink *topicSink) bool {
+	sink.subscriptions--
+	if sink.subscriptions > 0 {
+		return false
+	}
+	if s.sinks[topic] == sink {
+		delete(s.sinks, topic)
+	}
+	return true
+}
+
+func (s *Subscriber) request(ctx context.Context, hub string, data url.Values) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hub, strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.c.Do(req)
 	if err != nil {
 		return err
 	}
 	resp.Body.Close() // We don't need the response body
 
-	if resp.StatusCode != http.StatusAccepted {
+	if resp.StatusCode != http.StatusAccepted && !(s.WebSub && resp.StatusCode/100 == 2) {
 		return HTTPError(resp.StatusCode)
 	}
 
@@ -76,50 +360,191 @@ func (s *Subscriber) request(hub string, data url.Values) error {
 }
 
 // Subscribe subscribes to a topic on a hub. Notifications are sent to notifies.
+// Notifications received before the hub confirms the subscription are
+// delivered too. notifies is closed when the subscription ends, after pending
+// notifications have been delivered. If notifies is nil, notifications are
+// passed to Handler instead.
+//
+// A topic can be subscribed to on several hubs, with the same notifies
+// channel. In this case identical notifications received from different hubs
+// are delivered once, and notifies is closed when the last subscription ends.
+//
+// Subscribe waits for the hub to verify the request, which may never happen.
+// Use SubscribeContext to set a timeout.
+//
+// If the hub rejects the request, a HTTPError is returned. If the publisher
+// denies the subscription, a DeniedError is returned.
 func (s *Subscriber) Subscribe(hub, topic string, notifies chan<- Event) error {
-	if _, ok := s.subscriptions[topic]; ok {
-		return errors.New("pubsubhubbub: already subscribed")
+	return unwrapSubscriptionError(s.SubscribeContext(context.Background(), hub, topic, notifies))
+}
+
+// unwrapSubscriptionError returns the error wrapped in a SubscriptionError.
+// Subscribe and Unsubscribe return errors as-is.
+func unwrapSubscriptionError(err error) error {
+	if subErr, ok := err.(*SubscriptionError); ok {
+		return subErr.Err
+	}
+	return err
+}
+
+// SubscribeContext is like Subscribe, but gives up when ctx is done. In this
+// case the pending subscription is removed, notifies is closed and a
+// SubscriptionError wrapping ErrVerificationTimeout or ctx.Err() is returned.
+func (s *Subscriber) SubscribeContext(ctx context.Context, hub, topic string, notifies chan<- Event) error {
+	if !s.acquire() {
+		return ErrShutdown
 	}
+	defer s.wg.Done()
+
+	ctx, cancel := s.withShutdown(ctx)
+	defer cancel()
 
 	secret, err := generateChallenge()
 	if err != nil {
 		return err
 	}
 
-	u, err := url.Parse(s.callbackURL)
+	id, err := randomString(16)
+	if err != nil {
+		return err
+	}
+	callbackURL, err := s.subscriptionCallback(id)
 	if err != nil {
 		return err
 	}
-	q := u.Query()
-	q.Set("topic", topic)
-	u.RawQuery = q.Encode()
-	callbackURL := u.String()
 
+	key := hubTopic{hub, topic}
+
+	s.locker.Lock()
+	if _, ok := s.subscriptions[key]; ok {
+		s.locker.Unlock()
+		return errors.New("pubsubhubbub: already subscribed")
+	}
+	if sink, ok := s.sinks[topic]; ok && sink.notifies != nil && sink.notifies != notifies {
+		s.locker.Unlock()
+		return errors.New("pubsubhubbub: already subscribed to topic with a different channel")
+	}
 	sub := &subscription{
+		id:           id,
+		hub:          hub,
+		topic:        topic,
 		callbackURL:  callbackURL,
-		notifies:     notifies,
 		secret:       secret,
+		sink:         s.sink(topic),
+		pending:      true,
 		subscribes:   make(chan error, 1),
 		unsubscribes: make(chan error, 1),
 	}
-	s.subscriptions[topic] = sub
+	attached := sub.sink.notifies == nil
+	sub.sink.notifies = notifies
+	s.subscriptions[key] = sub
+	s.callbacks[id] = sub
+	s.addIntent(sub, "subscribe")
+	s.locker.Unlock()
+
+	if err := s.request(ctx, hub, s.subscribeData(topic, sub)); err != nil {
+		s.locker.Lock()
+		if s.subscriptions[key] == sub {
+			delete(s.subscriptions, key)
+			delete(s.callbacks, id)
+			if !s.releaseSink(topic, sub.sink) && attached {
+				sub.sink.notifies = nil
+			}
+		}
+		s.locker.Unlock()
+		return &SubscriptionError{"subscribe", hub, topic, err}
+	}
+
+	select {
+	case err = <-sub.subscribes:
+	case <-ctx.Done():
+		s.locker.Lock()
+		if sub.pending {
+			s.remove(key, sub, verificationError(ctx))
+		}
+		s.locker.Unlock()
+		// Either the hub verified the request in the meantime, or remove sent
+		// the error
+		err = <-sub.subscribes
+	}
+	if err != nil {
+		return &SubscriptionError{"subscribe", hub, topic, err}
+	}
+	return nil
+}
 
+func (s *Subscriber) subscribeData(topic string, sub *subscription) url.Values {
 	data := make(url.Values)
-	data.Set("hub.callback", callbackURL)
+	data.Set("hub.callback", sub.callbackURL)
 	data.Set("hub.mode", "subscribe")
 	data.Set("hub.topic", topic)
-	data.Set("hub.secret", secret)
-	// hub.lease_seconds
-	if err := s.request(hub, data); err != nil {
-		return err
+	data.Set("hub.secret", sub.secret)
+	if lease := s.leaseSeconds(); lease > 0 {
+		data.Set("hub.lease_seconds", strconv.Itoa(lease))
 	}
+	return data
+}
 
-	return <-sub.subscribes
+func (s *Subscriber) leaseSeconds() int {
+	return int(s.Lease.Seconds())
 }
 
-// Unsubscribe unsubscribes from a topic on a hub.
+// Unsubscribe unsubscribes from a topic on a hub. If hub is empty, the topic
+// is unsubscribed from all hubs.
+//
+// Unsubscribe waits for the hub to verify the request, which may never happen.
+// Use UnsubscribeContext to set a timeout.
+//
+// If the hub rejects the request, a HTTPError is returned.
 func (s *Subscriber) Unsubscribe(hub, topic string) error {
-	sub, ok := s.subscriptions[topic]
+	return unwrapSubscriptionError(s.UnsubscribeContext(context.Background(), hub, topic))
+}
+
+// UnsubscribeContext is like Unsubscribe, but gives up when ctx is done. In
+// this case the subscription is kept and a SubscriptionError wrapping
+// ErrVerificationTimeout or ctx.Err() is returned.
+func (s *Subscriber) UnsubscribeContext(ctx context.Context, hub, topic string) error {
+	if !s.acquire() {
+		return ErrShutdown
+	}
+	defer s.wg.Done()
+
+	ctx, cancel := s.withShutdown(ctx)
+	defer cancel()
+
+	if hub != "" {
+		return s.unsubscribe(ctx, hub, topic)
+	}
+
+	var hubs []string
+	s.locker.Lock()
+	for key := range s.subscriptions {
+		if key.topic == topic {
+			hubs = append(hubs, key.hub)
+		}
+	}
+	s.locker.Unlock()
+	if len(hubs) == 0 {
+		return errors.New("pubsubhubbub: no such subsciption")
+	}
+
+	var firstErr error
+	for _, hub := range hubs {
+		if err := s.unsubscribe(ctx, hub, topic); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *Subscriber) unsubscribe(ctx context.Context, hub, topic string) error {
+	s.locker.Lock()
+	sub, ok := s.subscriptions[hubTopic{hub, topic}]
+	var in *intent
+	if ok {
+		in = s.addIntent(sub, "unsubscribe")
+	}
+	s.locker.Unlock()
 	if !ok {
 		return errors.New("pubsubhubbub: no such subsciption")
 	}
@@ -128,11 +553,51 @@ func (s *Subscriber) Unsubscribe(hub, topic string) error {
 	data.Set("hub.callback", sub.callbackURL)
 	data.Set("hub.mode", "unsubscribe")
 	data.Set("hub.topic", topic)
-	if err := s.request(hub, data); err != nil {
-		return err
+	if err := s.request(ctx, hub, data); err != nil {
+		s.locker.Lock()
+		sub.removeIntent(in)
+		s.locker.Unlock()
+		return &SubscriptionError{"unsubscribe", hub, topic, err}
 	}
 
-	return <-sub.unsubscribes
+	select {
+	case <-sub.unsubscribes:
+		return nil
+	case <-ctx.Done():
+		return &SubscriptionError{"unsubscribe", hub, topic, verificationError(ctx)}
+	}
+}
+
+// remove removes a subscription. If the subscription is pending, err is
+// returned to Subscribe. It must be called with s.locker held.
+func (s *Subscriber) remove(key hubTopic, sub *subscription, err error) {
+	delete(s.subscriptions, key)
+	delete(s.callbacks, sub.id)
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	if sub.pending {
+		if err == nil {
+			err = errSubscriptionRemoved
+		}
+		sub.subscribes <- err
+		close(sub.subscribes)
+		sub.pending = false
+	}
+	// The subscription is over, pending Unsubscribe calls are done
+	close(sub.unsubscribes)
+	sink := sub.sink
+	if s.releaseSink(key.topic, sink) && sink.notifies != nil {
+		// Notifications are delivered without holding the lock
+		notifies := sink.notifies
+		go func() {
+			sink.deliveries.Wait()
+			close(notifies)
+		}()
+	}
+	if err := s.store.Delete(key.topic, sub.callbackURL); err != nil {
+		s.logger().Error("cannot remove subscription", "topic", key.topic, "callback", sub.callbackURL, "err", err)
+	}
 }
 
 // ServeHTTP implements http.Handler.
@@ -145,35 +610,82 @@ func (s *Subscriber) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 		mode := query.Get("hub.mode")
 		topic := query.Get("hub.topic")
 
-		sub, ok := s.subscriptions[topic]
-		if !ok {
+		s.locker.Lock()
+		defer s.locker.Unlock()
+
+		sub, ok := s.callbacks[s.callbackID(req.URL)]
+		if !ok || sub.topic != topic {
+			s.logger().Warn("unexpected verification request", "mode", mode, "topic", topic, "callback", req.URL.String())
 			http.Error(resp, "Not Found", http.StatusNotFound)
 			return
 		}
+		key := hubTopic{sub.hub, topic}
 
 		switch mode {
 		case "denied":
 			reason := query.Get("hub.reason")
-			log.Printf("pubsubhubbub: publisher denied request for topic %q (reason: %v)\n", topic, reason)
-			delete(s.subscriptions, topic)
-			close(sub.notifies)
-			sub.subscribes <- DeniedError(reason)
-			close(sub.subscribes)
+			if !sub.matchIntent("subscribe") {
+				// Publishers can revoke a subscription at any time, so a denial
+				// doesn't always answer a request. Since it cannot be told apart
+				// from a spoofed one, ask the hub to confirm the subscription
+				// instead: it will deny it again if it has really been revoked.
+				s.logger().Warn("unexpected verification request", "mode", mode, "hub", sub.hub, "topic", topic, "reason", reason)
+				if !sub.pending && !sub.hasIntent("subscribe") {
+					s.scheduleRenewal(key, sub, 0)
+				}
+				http.Error(resp, "Not Found", http.StatusNotFound)
+				return
+			}
+			s.logger().Warn("publisher denied subscription", "hub", sub.hub, "topic", topic, "reason", reason)
+			pending := sub.pending
+			s.remove(key, sub, DeniedError(reason))
+			if !pending && s.SubscriptionLost != nil {
+				go s.SubscriptionLost(sub.hub, topic, DeniedError(reason))
+			}
 			return
 		case "subscribe":
-			log.Printf("pubsubhubbub: publisher accepted subscription for topic %q\n", topic)
 			lease, err := strconv.Atoi(query.Get("hub.lease_seconds"))
 			if err != nil {
 				http.Error(resp, "Bad Request", http.StatusBadRequest)
 				return
 			}
-			sub.lease = time.Now().Add(time.Duration(lease) * time.Second)
-			close(sub.subscribes)
+			if !sub.matchIntent(mode) {
+				s.logger().Warn("unexpected verification request", "mode", mode, "hub", sub.hub, "topic", topic)
+				http.Error(resp, "Not Found", http.StatusNotFound)
+				return
+			}
+			leaseDuration := time.Duration(lease) * time.Second
+			s.logger().Info("publisher accepted subscription", "hub", sub.hub, "topic", topic, "lease", leaseDuration)
+			sub.lease = time.Now().Add(leaseDuration)
+			err = s.store.Put(&Subscription{
+				Hub:      sub.hub,
+				Topic:    topic,
+				Callback: sub.callbackURL,
+				Secret:   sub.secret,
+				LeaseEnd: sub.lease,
+			})
+			if err != nil {
+				s.logger().Error("cannot save subscription", "topic", topic, "callback", sub.callbackURL, "err", err)
+				http.Error(resp, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if sub.pending {
+				sub.pending = false
+				close(sub.subscribes)
+			}
+
+			sub.renewals++
+			sub.attempts = 0
+			sub.lastErr = nil
+			s.scheduleRenewal(key, sub, s.renewalDelay(leaseDuration))
 		case "unsubscribe":
-			log.Printf("pubsubhubbub: publisher accepted unsubscription for topic %q\n", topic)
-			delete(s.subscriptions, topic)
-			close(sub.notifies)
-			close(sub.unsubscribes)
+			if !sub.matchIntent(mode) {
+				s.logger().Warn("unexpected verification request", "mode", mode, "hub", sub.hub, "topic", topic)
+				http.Error(resp, "Not Found", http.StatusNotFound)
+				return
+			}
+			s.logger().Info("publisher accepted unsubscription", "hub", sub.hub, "topic", topic)
+			s.remove(key, sub, nil)
 		default:
 			http.Error(resp, "Bad Request", http.StatusBadRequest)
 			return
@@ -181,18 +693,59 @@ func (s *Subscriber) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 
 		resp.Write([]byte(query.Get("hub.challenge")))
 	case http.MethodPost:
-		topic := query.Get("topic")
-
-		sub, ok := s.subscriptions[topic]
-		if !ok {
+		if !s.acquire() {
+			http.Error(resp, "Shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.wg.Done()
+
+		s.locker.Lock()
+		sub, ok := s.callbacks[s.callbackID(req.URL)]
+		var topic string
+		var handler EventHandler
+		if ok {
+			topic = sub.topic
+			if sub.sink.notifies != nil {
+				handler = channelHandler(sub.sink.notifies)
+				// The channel must not be closed while in use
+				sub.sink.deliveries.Add(1)
+				defer sub.sink.deliveries.Done()
+			} else if s.Handler != nil {
+				handler = s.Handler
+			}
+		}
+		s.locker.Unlock()
+		if !ok && s.WebSub {
+			http.Error(resp, "Subscription gone", http.StatusGone)
+			return
+		} else if !ok {
 			http.Error(resp, "Invalid topic", http.StatusNotFound)
 			return
 		}
+		if handler == nil {
+			// The subscription has been restored but no channel is attached yet,
+			// ask the hub to try again later
+			http.Error(resp, "Subscription not attached", http.StatusServiceUnavailable)
+			return
+		}
 
-		var r io.Reader = req.Body
+		digest := sha256.New()
+		var r io.Reader = io.TeeReader(req.Body, digest)
 		var h hash.Hash
+		var mac []byte
 		if sub.secret != "" {
-			h = hmac.New(sha1.New, []byte(sub.secret))
+			var err error
+			h, mac, err = parseSignature(req.Header.Get("X-Hub-Signature"), sub.secret)
+			if err == errUnsupportedSignature {
+				s.logger().Warn("unsupported signature algorithm", "hub", sub.hub, "topic", topic, "signature", req.Header.Get("X-Hub-Signature"))
+				http.Error(resp, "Unsupported signature algorithm", http.StatusBadRequest)
+				return
+			} else if err != nil {
+				// Invalid signature
+				// Ignore message, do not return an error
+				s.logger().Warn("invalid signature", "hub", sub.hub, "topic", topic, "err", err)
+				return
+			}
 			r = io.TeeReader(r, h)
 		}
 
@@ -212,17 +765,33 @@ func (s *Subscriber) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 
 		// Check signature
 		if h != nil {
-			s := strings.TrimPrefix(req.Header.Get("X-Hub-Signature"), "sha1=")
-			mac, err := hex.DecodeString(s)
-			if err != nil || !hmac.Equal(mac, h.Sum(nil)) {
+			if !hmac.Equal(mac, h.Sum(nil)) {
 				// Invalid signature
 				// Ignore message, do not return an error
-				log.Printf("pubsubhubbub: invalid signature for topic %q\n", topic)
+				s.logger().Warn("invalid signature", "hub", sub.hub, "topic", topic)
 				return
 			}
 		}
 
-		sub.notifies <- event
+		recent := recentNotification{hub: sub.hub}
+		copy(recent.digest[:], digest.Sum(nil))
+		s.locker.Lock()
+		duplicate := sub.sink.seen(recent)
+		s.locker.Unlock()
+		if duplicate {
+			// Already received through another hub
+			return
+		}
+
+		ctx, cancel := s.withShutdown(req.Context())
+		defer cancel()
+		if err := handler.HandleEvent(ctx, topic, event); err != nil {
+			// The hub will retry, don't drop the notification as a duplicate
+			s.locker.Lock()
+			sub.sink.forget(recent)
+			s.locker.Unlock()
+			http.Error(resp, "Notification not delivered", eventErrorStatus(err))
+		}
 	default:
 		http.Error(resp, "Unsupported method", http.StatusMethodNotAllowed)
 	}
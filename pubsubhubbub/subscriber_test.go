@@ -0,0 +1,462 @@
+package pubsubhubbub
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ostatus/activitystream"
+)
+
+func testFeed(topicURL string) *activitystream.Feed {
+	return &activitystream.Feed{
+		ID:    topicURL,
+		Title: "Test notification",
+		Link: []activitystream.Link{
+			{Rel: "self", Type: "application/atom+xml", Href: topicURL},
+		},
+	}
+}
+
+// TestSubscriber_concurrent should be run with the race detector.
+func TestSubscriber_concurrent(t *testing.T) {
+	const (
+		topics        = 20
+		notifications = 5
+	)
+
+	hubURL := "http://localhost/publisher/hub"
+
+	be := newDummyBackend()
+	pub := NewPublisher(be)
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	pub.c.Transport = &roundTripper{sub}
+	sub.c.Transport = &roundTripper{pub}
+
+	var wg sync.WaitGroup
+	for i := 0; i < topics; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			topicURL := fmt.Sprintf("http://localhost/publisher/topic-%v.atom", i)
+			notifies := make(chan Event)
+			if err := sub.Subscribe(hubURL, topicURL, notifies); err != nil {
+				t.Errorf("Subscribe(%v) = %v", topicURL, err)
+				return
+			}
+
+			// A second subscription to the same topic must fail
+			if err := sub.Subscribe(hubURL, topicURL, make(chan Event)); err == nil {
+				t.Errorf("Subscribe(%v) twice = nil, want an error", topicURL)
+			}
+
+			go func() {
+				for j := 0; j < notifications; j++ {
+					be.notifies(topicURL) <- testFeed(topicURL)
+				}
+			}()
+
+			for j := 0; j < notifications; j++ {
+				select {
+				case event := <-notifies:
+					if event.Topic() != topicURL {
+						t.Errorf("Invalid notification topic: expected %v but got %v", topicURL, event.Topic())
+					}
+				case <-time.After(5 * time.Second):
+					t.Errorf("Notification %v for %v not received", j, topicURL)
+					return
+				}
+			}
+
+			if err := sub.Unsubscribe(hubURL, topicURL); err != nil {
+				t.Errorf("Unsubscribe(%v) = %v", topicURL, err)
+				return
+			}
+
+			select {
+			case _, ok := <-notifies:
+				if ok {
+					t.Errorf("Unexpected notification for %v after unsubscribing", topicURL)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("Channel for %v not closed after unsubscribing", topicURL)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// postNotification sends a notification signed with secret to a subscriber.
+func postNotification(ctx context.Context, h http.Handler, callbackURL, secret string, feed *activitystream.Feed) *httptest.ResponseRecorder {
+	var b bytes.Buffer
+	feed.WriteTo(&b)
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(b.Bytes())
+
+	req := httptest.NewRequest(http.MethodPost, callbackURL, &b).WithContext(ctx)
+	req.Header.Set("Content-Type", feed.MediaType())
+	req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestSubscriber_pending(t *testing.T) {
+	topicURL := "http://localhost/topic.atom"
+
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	// This hub accepts requests but never verifies them
+	sub.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusAccepted)
+	})}
+
+	notifies := make(chan Event, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- sub.Subscribe("http://localhost/hub", topicURL, notifies)
+	}()
+
+	// Wait for the subscription to be pending
+	var callbackURL, secret string
+	for callbackURL == "" {
+		sub.locker.Lock()
+		if s, ok := sub.subscriptions[hubTopic{"http://localhost/hub", topicURL}]; ok {
+			callbackURL, secret = s.callbackURL, s.secret
+		}
+		sub.locker.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	// Notifications are delivered while the subscription is pending
+	w := postNotification(context.Background(), sub, callbackURL, secret, testFeed(topicURL))
+	if w.Code/100 != 2 {
+		t.Fatalf("Notification rejected: %v", w.Code)
+	}
+	<-notifies
+
+	// A notification is being delivered to a busy consumer when the hub
+	// confirms an unsubscription
+	ctx, cancel := context.WithCancel(context.Background())
+	delivering := make(chan struct{})
+	go func() {
+		notifies <- testFeed(topicURL) // Fill the channel
+		close(delivering)
+		postNotification(ctx, sub, callbackURL, secret, testFeed(topicURL))
+	}()
+	<-delivering
+	time.Sleep(10 * time.Millisecond)
+
+	unsubscribed := make(chan error, 1)
+	go func() {
+		unsubscribed <- sub.Unsubscribe("http://localhost/hub", topicURL)
+	}()
+
+	// Wait for the unsubscription request to be sent
+	for sent := false; !sent; {
+		sub.locker.Lock()
+		for _, in := range sub.subscriptions[hubTopic{"http://localhost/hub", topicURL}].intents {
+			sent = sent || in.mode == "unsubscribe"
+		}
+		sub.locker.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	w = httptest.NewRecorder()
+	sub.ServeHTTP(w, httptest.NewRequest(http.MethodGet, callbackURL+"&hub.mode=unsubscribe&hub.topic="+topicURL+"&hub.challenge=c", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unsubscription verification failed: %v", w.Code)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected pending Subscribe to fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pending Subscribe still blocked after unsubscription")
+	}
+	if err := <-unsubscribed; err != nil {
+		t.Error("Unsubscribe() =", err)
+	}
+
+	// The channel must not be closed while a notification is in flight
+	cancel()
+	for range notifies {
+	}
+}
+
+func TestSubscriber_SubscribeContext(t *testing.T) {
+	hubURL := "http://localhost/hub"
+	topicURL := "http://localhost/topic.atom"
+
+	var sub *Subscriber
+	tests := []struct {
+		name  string
+		hub   http.HandlerFunc
+		check func(err error) bool
+	}{
+		{
+			name: "rejected",
+			hub: func(resp http.ResponseWriter, req *http.Request) {
+				resp.WriteHeader(http.StatusBadRequest)
+			},
+			check: func(err error) bool {
+				var httpErr HTTPError
+				return errors.As(err, &httpErr) && httpErr == http.StatusBadRequest
+			},
+		},
+		{
+			name: "timeout",
+			hub: func(resp http.ResponseWriter, req *http.Request) {
+				resp.WriteHeader(http.StatusAccepted)
+			},
+			check: func(err error) bool {
+				return errors.Is(err, ErrVerificationTimeout)
+			},
+		},
+		{
+			name: "denied",
+			hub: func(resp http.ResponseWriter, req *http.Request) {
+				callbackURL := req.FormValue("hub.callback") + "&hub.mode=denied&hub.topic=" + topicURL + "&hub.reason=nope"
+				go sub.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, callbackURL, nil))
+				resp.WriteHeader(http.StatusAccepted)
+			},
+			check: func(err error) bool {
+				var deniedErr DeniedError
+				return errors.As(err, &deniedErr) && deniedErr == "nope"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		sub = NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+		sub.c.Transport = &roundTripper{test.hub}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		notifies := make(chan Event)
+		err := sub.SubscribeContext(ctx, hubURL, topicURL, notifies)
+		cancel()
+
+		var subErr *SubscriptionError
+		if !errors.As(err, &subErr) {
+			t.Errorf("%v: SubscribeContext() = %v, want a SubscriptionError", test.name, err)
+			continue
+		}
+		if subErr.Mode != "subscribe" || subErr.Hub != hubURL || subErr.Topic != topicURL {
+			t.Errorf("%v: invalid SubscriptionError: %+v", test.name, subErr)
+		}
+		if !test.check(err) {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+		}
+
+		sub.locker.Lock()
+		n := len(sub.subscriptions)
+		sub.locker.Unlock()
+		if n != 0 {
+			t.Errorf("%v: subscription not removed", test.name)
+		}
+	}
+}
+
+func TestSubscriber_Subscribe_errors(t *testing.T) {
+	hubURL := "http://localhost/hub"
+	topicURL := "http://localhost/topic.atom"
+
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	sub.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusBadRequest)
+	})}
+	err := sub.Subscribe(hubURL, topicURL, make(chan Event))
+	if httpErr, ok := err.(HTTPError); !ok || httpErr != http.StatusBadRequest {
+		t.Errorf("Subscribe() = %#v, want a HTTPError", err)
+	}
+
+	sub = NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	sub.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		callbackURL := req.FormValue("hub.callback") + "&hub.mode=denied&hub.topic=" + topicURL + "&hub.reason=nope"
+		go sub.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, callbackURL, nil))
+		resp.WriteHeader(http.StatusAccepted)
+	})}
+	err = sub.Subscribe(hubURL, topicURL, make(chan Event))
+	if deniedErr, ok := err.(DeniedError); !ok || deniedErr != "nope" {
+		t.Errorf("Subscribe() = %#v, want a DeniedError", err)
+	}
+}
+
+func TestSubscriber_UnsubscribeContext(t *testing.T) {
+	hubURL := "http://localhost/hub"
+	topicURL := "http://localhost/topic.atom"
+
+	be := newDummyBackend()
+	pub := NewPublisher(be)
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	pub.c.Transport = &roundTripper{sub}
+	sub.c.Transport = &roundTripper{pub}
+
+	if err := sub.Subscribe(hubURL, topicURL, make(chan Event)); err != nil {
+		t.Fatal("Subscribe() =", err)
+	}
+
+	// The hub stops verifying requests
+	sub.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusAccepted)
+	})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := sub.UnsubscribeContext(ctx, hubURL, topicURL); !errors.Is(err, ErrVerificationTimeout) {
+		t.Errorf("UnsubscribeContext() = %v, want %v", err, ErrVerificationTimeout)
+	}
+
+	sub.locker.Lock()
+	_, ok := sub.subscriptions[hubTopic{"http://localhost/hub", topicURL}]
+	sub.locker.Unlock()
+	if !ok {
+		t.Error("Subscription removed after a failed unsubscription")
+	}
+}
+
+func TestSubscriber_multipleHubs(t *testing.T) {
+	topicURL := "http://localhost/topic.atom"
+	hubs := []string{"http://localhost/hub1", "http://localhost/hub2"}
+
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	mux := http.NewServeMux()
+	sub.c.Transport = &roundTripper{mux}
+
+	registered := make(chan struct{}, len(hubs))
+	var backends []*dummyBackend
+	for _, hubURL := range hubs {
+		be := newDummyBackend()
+		pub := NewPublisher(be)
+		pub.c.Transport = &roundTripper{sub}
+		pub.SubscriptionState = func(topicURL, callbackURL, secret string, leaseEnd time.Time) {
+			registered <- struct{}{}
+		}
+		mux.Handle(strings.TrimPrefix(hubURL, "http://localhost"), pub)
+		backends = append(backends, be)
+	}
+
+	notifies := make(chan Event, 10)
+	for _, hubURL := range hubs {
+		if err := sub.Subscribe(hubURL, topicURL, notifies); err != nil {
+			t.Fatalf("Subscribe(%v) = %v", hubURL, err)
+		}
+		<-registered
+	}
+
+	if err := sub.Subscribe(hubs[0], topicURL, notifies); err == nil {
+		t.Error("Expected a second subscription on the same hub to fail")
+	}
+	if err := sub.Subscribe("http://localhost/hub3", topicURL, make(chan Event)); err == nil {
+		t.Error("Expected a subscription with another channel to fail")
+	}
+
+	// The same notification is received through both hubs
+	feed := testFeed(topicURL)
+	for _, be := range backends {
+		be.notifies(topicURL) <- feed
+	}
+
+	<-notifies
+	select {
+	case <-notifies:
+		t.Error("Duplicate notification not filtered")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := sub.Unsubscribe(hubs[0], topicURL); err != nil {
+		t.Fatal("Unsubscribe() =", err)
+	}
+	<-registered
+
+	// The channel is still open for the other hub
+	feed = testFeed(topicURL)
+	feed.Title = "Another notification"
+	backends[1].notifies(topicURL) <- feed
+	select {
+	case _, ok := <-notifies:
+		if !ok {
+			t.Fatal("Channel closed while subscribed to another hub")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Notification not received")
+	}
+
+	if err := sub.Unsubscribe("", topicURL); err != nil {
+		t.Fatal("Unsubscribe(all hubs) =", err)
+	}
+	select {
+	case _, ok := <-notifies:
+		if ok {
+			t.Error("Unexpected notification after unsubscribing")
+		}
+	case <-time.After(time.Second):
+		t.Error("Channel not closed after unsubscribing from all hubs")
+	}
+}
+
+func TestSubscriber_Logger(t *testing.T) {
+	hubURL := "http://localhost/hub"
+	topicURL := "http://localhost/topic.atom"
+
+	var logs bytes.Buffer
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	sub.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	// This hub accepts requests but never verifies them
+	sub.c.Transport = &roundTripper{http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusAccepted)
+	})}
+
+	go sub.Subscribe(hubURL, topicURL, make(chan Event))
+
+	// Wait for the subscription to be pending
+	var callbackURL string
+	for callbackURL == "" {
+		sub.locker.Lock()
+		if s, ok := sub.subscriptions[hubTopic{hubURL, topicURL}]; ok {
+			callbackURL = s.callbackURL
+		}
+		sub.locker.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	postNotification(context.Background(), sub, callbackURL, "invalid secret", testFeed(topicURL))
+
+	var record struct {
+		Level string
+		Msg   string
+		Hub   string
+		Topic string
+	}
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatal("Invalid log record:", err)
+	}
+	if record.Level != "WARN" || record.Msg != "invalid signature" || record.Hub != hubURL || record.Topic != topicURL {
+		t.Errorf("Invalid log record: %v", logs.String())
+	}
+}
+
+func TestSubscriber_nilLogger(t *testing.T) {
+	sub := NewSubscriber("http://localhost/subscriber/webhook", readAtomEvent)
+	sub.Logger = nil
+
+	code := verify(sub, "http://localhost/subscriber/webhook?id=unknown", "subscribe", "http://localhost/topic.atom", "60")
+	if code != http.StatusNotFound {
+		t.Errorf("Expected status %v for an unknown callback, got %v", http.StatusNotFound, code)
+	}
+}
@@ -0,0 +1,218 @@
+package pubsubhubbub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// websubServers runs a WebSub publisher and subscriber on local HTTP servers.
+type websubServers struct {
+	be  *dummyBackend
+	pub *Publisher
+	sub *Subscriber
+
+	hub        *httptest.Server
+	subscriber *httptest.Server
+
+	locker        sync.Mutex
+	verifications []url.Values
+	distributions []http.Header
+	gone          bool
+
+	// states receives the publisher's subscription lease ends
+	states chan time.Time
+}
+
+func newWebSubServers(t *testing.T) *websubServers {
+	s := &websubServers{
+		be:     newDummyBackend(),
+		states: make(chan time.Time, 10),
+	}
+
+	s.hub = httptest.NewServer(nil)
+	t.Cleanup(s.hub.Close)
+	s.subscriber = httptest.NewServer(http.HandlerFunc(s.serveSubscriber))
+	t.Cleanup(s.subscriber.Close)
+
+	s.pub = NewPublisher(s.be)
+	s.pub.WebSub = true
+	s.pub.HubURL = s.hub.URL
+	s.pub.SubscriptionState = func(topicURL, callbackURL, secret string, leaseEnd time.Time) {
+		s.states <- leaseEnd
+	}
+	s.hub.Config.Handler = s.pub
+
+	s.sub = NewSubscriber(s.subscriber.URL+"/callback", readAtomEvent)
+	s.sub.WebSub = true
+
+	return s
+}
+
+// waitState waits for the publisher to change a subscription's state.
+func (s *websubServers) waitState(t *testing.T) time.Time {
+	t.Helper()
+
+	select {
+	case leaseEnd := <-s.states:
+		return leaseEnd
+	case <-time.After(time.Second):
+		t.Fatal("Subscription state unchanged")
+	}
+	return time.Time{}
+}
+
+func (s *websubServers) serveSubscriber(resp http.ResponseWriter, req *http.Request) {
+	s.locker.Lock()
+	switch req.Method {
+	case http.MethodGet:
+		s.verifications = append(s.verifications, req.URL.Query())
+	case http.MethodPost:
+		s.distributions = append(s.distributions, req.Header.Clone())
+		if s.gone {
+			s.locker.Unlock()
+			http.Error(resp, "Gone", http.StatusGone)
+			return
+		}
+	}
+	s.locker.Unlock()
+
+	s.sub.ServeHTTP(resp, req)
+}
+
+func TestWebSub_subscribe(t *testing.T) {
+	s := newWebSubServers(t)
+	s.sub.Lease = time.Hour
+
+	topicURL := "http://example.org/topic.atom"
+	notifies := make(chan Event, 1)
+	if err := s.sub.Subscribe(s.hub.URL, topicURL, notifies); err != nil {
+		t.Fatal("Subscribe() =", err)
+	}
+
+	s.locker.Lock()
+	q := s.verifications[0]
+	s.locker.Unlock()
+	if q.Get("hub.mode") != "subscribe" || q.Get("hub.topic") != topicURL || q.Get("hub.challenge") == "" {
+		t.Errorf("Invalid verification request: %v", q)
+	}
+	if lease, err := strconv.Atoi(q.Get("hub.lease_seconds")); err != nil || lease != 3600 {
+		t.Errorf("Expected the requested lease to be echoed, got %q", q.Get("hub.lease_seconds"))
+	}
+	s.waitState(t)
+
+	s.be.notifies(topicURL) <- testFeed(topicURL)
+	select {
+	case <-notifies:
+	case <-time.After(time.Second):
+		t.Fatal("Notification not received")
+	}
+
+	s.locker.Lock()
+	h := s.distributions[0]
+	s.locker.Unlock()
+	links := strings.Join(h.Values("Link"), ", ")
+	for _, want := range []string{"<" + s.hub.URL + `>; rel="hub"`, "<" + topicURL + `>; rel="self"`} {
+		if !strings.Contains(links, want) {
+			t.Errorf("Missing link %v in content distribution request: %v", want, links)
+		}
+	}
+	if sig := h.Get("X-Hub-Signature"); sig == "" {
+		t.Error("Content distribution request not signed")
+	}
+}
+
+func TestWebSub_derivedHubURL(t *testing.T) {
+	s := newWebSubServers(t)
+	s.pub.HubURL = ""
+
+	hubURL := s.hub.URL + "/hub"
+	topicURL := "http://example.org/topic.atom"
+	notifies := make(chan Event, 1)
+	if err := s.sub.Subscribe(hubURL, topicURL, notifies); err != nil {
+		t.Fatal("Subscribe() =", err)
+	}
+	s.waitState(t)
+
+	s.be.notifies(topicURL) <- testFeed(topicURL)
+	select {
+	case <-notifies:
+	case <-time.After(time.Second):
+		t.Fatal("Notification not received")
+	}
+
+	s.locker.Lock()
+	h := s.distributions[0]
+	s.locker.Unlock()
+	links := strings.Join(h.Values("Link"), ", ")
+	if want := "<" + hubURL + `>; rel="hub"`; !strings.Contains(links, want) {
+		t.Errorf("Missing link %v in content distribution request: %v", want, links)
+	}
+}
+
+func TestWebSub_gone(t *testing.T) {
+	s := newWebSubServers(t)
+
+	topicURL := "http://example.org/topic.atom"
+	if err := s.sub.Subscribe(s.hub.URL, topicURL, make(chan Event, 1)); err != nil {
+		t.Fatal("Subscribe() =", err)
+	}
+	s.waitState(t)
+
+	s.locker.Lock()
+	s.gone = true
+	s.locker.Unlock()
+
+	s.be.notifies(topicURL) <- testFeed(topicURL)
+	if leaseEnd := s.waitState(t); !leaseEnd.IsZero() {
+		t.Fatal("Callback answering 410 Gone not unsubscribed")
+	}
+}
+
+func TestWebSub_unknownCallback(t *testing.T) {
+	s := newWebSubServers(t)
+
+	resp, err := http.Post(s.subscriber.URL+"/callback?topic=http://example.org/unknown.atom", "application/atom+xml", strings.NewReader("<feed/>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("Expected status %v for an unknown callback, got %v", http.StatusGone, resp.StatusCode)
+	}
+}
+
+func TestWebSub_acceptedStatus(t *testing.T) {
+	topicURL := "http://example.org/topic.atom"
+
+	var sub *Subscriber
+	hub := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		// Verify synchronously, then answer 204 instead of 202
+		q := make(url.Values)
+		q.Set("hub.mode", "subscribe")
+		q.Set("hub.topic", req.FormValue("hub.topic"))
+		q.Set("hub.challenge", "challenge")
+		q.Set("hub.lease_seconds", "3600")
+		w := httptest.NewRecorder()
+		go sub.ServeHTTP(w, httptest.NewRequest(http.MethodGet, req.FormValue("hub.callback")+"&"+q.Encode(), nil))
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	defer hub.Close()
+
+	for _, websub := range []bool{false, true} {
+		sub = NewSubscriber("http://localhost/callback", readAtomEvent)
+		sub.WebSub = websub
+
+		err := sub.Subscribe(hub.URL, topicURL, make(chan Event))
+		if websub && err != nil {
+			t.Errorf("WebSub: Subscribe() = %v", err)
+		} else if !websub && err == nil {
+			t.Error("PubSubHubbub 0.4: expected 204 No Content to be rejected")
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Package rss implements RSS 2.0, as defined in
+// https://www.rssboard.org/rss-specification.
+package rss
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/emersion/go-ostatus/pubsubhubbub"
+)
+
+// MediaType is the media type of RSS feeds.
+const MediaType = "application/rss+xml"
+
+// Version is the RSS version implemented by this package.
+const Version = "2.0"
+
+const timeLayout = time.RFC1123Z
+
+// A Feed is an RSS feed.
+type Feed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel *Channel `xml:"channel"`
+}
+
+// NewFeed creates a new feed with a channel.
+func NewFeed(ch *Channel) *Feed {
+	return &Feed{Version: Version, Channel: ch}
+}
+
+func init() {
+	pubsubhubbub.RegisterEventFormat(MediaType, ReadEvent)
+}
+
+// Read parses a feed from r.
+func Read(r io.Reader) (*Feed, error) {
+	feed := new(Feed)
+	err := xml.NewDecoder(r).Decode(feed)
+	return feed, err
+}
+
+// ReadEvent is a pubsubhubbub.ReadEventFunc that reads RSS feeds. Importing this
+// package registers it with pubsubhubbub.RegisterEventFormat.
+func ReadEvent(mediaType string, body io.Reader) (pubsubhubbub.Event, error) {
+	feed, err := Read(body)
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// WriteTo writes the feed to w.
+func (feed *Feed) WriteTo(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(feed)
+}
+
+// MediaType returns this feed's media type.
+func (feed *Feed) MediaType() string {
+	return MediaType
+}
+
+// Topic returns this feed's topic, which is the URL of its Atom self link.
+func (feed *Feed) Topic() string {
+	if feed.Channel == nil {
+		return ""
+	}
+	for _, link := range feed.Channel.AtomLink {
+		if link.Rel == "self" {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// A Channel describes a feed and contains its items.
+type Channel struct {
+	// AtomLink contains Atom links, usually for the self and hub relations. It
+	// must come before Link, which would match Atom links otherwise.
+	AtomLink []AtomLink `xml:"http://www.w3.org/2005/Atom link"`
+
+	Title         string `xml:"title"`
+	Link          string `xml:"link"`
+	Description   string `xml:"description"`
+	Language      string `xml:"language,omitempty"`
+	Copyright     string `xml:"copyright,omitempty"`
+	PubDate       Time   `xml:"pubDate,omitempty"`
+	LastBuildDate Time   `xml:"lastBuildDate,omitempty"`
+	Generator     string `xml:"generator,omitempty"`
+	TTL           int    `xml:"ttl,omitempty"`
+	Image         *Image `xml:"image"`
+
+	Item []*Item `xml:"item"`
+}
+
+// An Image is a picture that can be displayed with a channel.
+type Image struct {
+	URL   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+}
+
+// An AtomLink is an Atom link embedded in an RSS channel.
+type AtomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// An Item is a feed item.
+type Item struct {
+	Title       string     `xml:"title,omitempty"`
+	Link        string     `xml:"link,omitempty"`
+	Description string     `xml:"description,omitempty"`
+	Author      string     `xml:"author,omitempty"`
+	Category    []string   `xml:"category"`
+	Comments    string     `xml:"comments,omitempty"`
+	Enclosure   *Enclosure `xml:"enclosure"`
+	GUID        *GUID      `xml:"guid"`
+	PubDate     Time       `xml:"pubDate,omitempty"`
+}
+
+// An Enclosure is a media object attached to an item.
+type Enclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// A GUID uniquely identifies an item.
+type GUID struct {
+	Value string `xml:",chardata"`
+	// IsPermaLink is false if the GUID isn't a URL. If nil, it's true.
+	IsPermaLink *bool `xml:"isPermaLink,attr"`
+}
+
+// A Time is a formatted time.
+type Time string
+
+// NewTime formats a time.
+func NewTime(t time.Time) Time {
+	return Time(t.Format(timeLayout))
+}
+
+// Time parses a formatted time. Time zones can be numeric, as written by
+// NewTime, or abbreviated, as in "Mon, 02 Jan 2006 15:04:05 GMT".
+func (t Time) Time() (time.Time, error) {
+	parsed, err := time.Parse(timeLayout, string(t))
+	if err != nil {
+		if parsed, rfc1123Err := time.Parse(time.RFC1123, string(t)); rfc1123Err == nil {
+			return parsed, nil
+		}
+	}
+	return parsed, err
+}
@@ -0,0 +1,92 @@
+package rss
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+	<channel>
+		<title>Example</title>
+		<link>https://example.com/</link>
+		<description>An example feed</description>
+		<atom:link rel="self" href="https://example.com/feed.rss" type="application/rss+xml"/>
+		<atom:link rel="hub" href="https://hub.example.com/"/>
+		<item>
+			<title>Hello World!</title>
+			<link>https://example.com/hello</link>
+			<guid isPermaLink="false">hello</guid>
+			<pubDate>Sun, 23 Apr 2017 10:00:00 +0000</pubDate>
+		</item>
+	</channel>
+</rss>`
+
+func TestRead(t *testing.T) {
+	feed, err := Read(strings.NewReader(testFeed))
+	if err != nil {
+		t.Fatal("Read() =", err)
+	}
+
+	if feed.Channel.Link != "https://example.com/" {
+		t.Errorf("Invalid channel link: %q", feed.Channel.Link)
+	}
+	if topic := feed.Topic(); topic != "https://example.com/feed.rss" {
+		t.Errorf("Invalid topic: %q", topic)
+	}
+	if len(feed.Channel.Item) != 1 {
+		t.Fatalf("Expected one item, got %v", len(feed.Channel.Item))
+	}
+	item := feed.Channel.Item[0]
+	if item.GUID == nil || item.GUID.Value != "hello" || item.GUID.IsPermaLink == nil || *item.GUID.IsPermaLink {
+		t.Errorf("Invalid item GUID: %+v", item.GUID)
+	}
+	if date, err := item.PubDate.Time(); err != nil || date.Year() != 2017 {
+		t.Errorf("Invalid item date: %v (%v)", date, err)
+	}
+}
+
+func TestFeed_WriteTo(t *testing.T) {
+	feed, err := Read(strings.NewReader(testFeed))
+	if err != nil {
+		t.Fatal("Read() =", err)
+	}
+
+	var b bytes.Buffer
+	if err := feed.WriteTo(&b); err != nil {
+		t.Fatal("WriteTo() =", err)
+	}
+
+	feed, err = Read(&b)
+	if err != nil {
+		t.Fatal("Read() =", err)
+	}
+	if topic := feed.Topic(); topic != "https://example.com/feed.rss" {
+		t.Errorf("Invalid topic after round-trip: %q", topic)
+	}
+	if feed.Channel.Link != "https://example.com/" {
+		t.Errorf("Invalid channel link after round-trip: %q", feed.Channel.Link)
+	}
+}
+
+func TestTime(t *testing.T) {
+	want := time.Date(2017, time.April, 23, 10, 0, 0, 0, time.UTC)
+
+	for _, s := range []Time{"Sun, 23 Apr 2017 10:00:00 +0000", "Sun, 23 Apr 2017 10:00:00 GMT"} {
+		date, err := s.Time()
+		if err != nil {
+			t.Errorf("Time(%q) = %v", s, err)
+		} else if !date.Equal(want) {
+			t.Errorf("Time(%q) = %v, want %v", s, date, want)
+		}
+	}
+
+	if _, err := Time("yesterday").Time(); err == nil {
+		t.Error("Expected an error for an invalid time")
+	}
+	if s := NewTime(want); s != "Sun, 23 Apr 2017 10:00:00 +0000" {
+		t.Errorf("NewTime() = %q", s)
+	}
+}
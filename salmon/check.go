@@ -0,0 +1,129 @@
+package salmon
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/emersion/go-ostatus/activitystream"
+)
+
+// A Check is a consistency check performed on incoming salmons, in addition to
+// signature verification. Checks can be combined with a bitwise OR.
+type Check int
+
+const (
+	// CheckEntryID checks that the entry's ID belongs to the signer's domain.
+	// IDs that don't contain a domain, such as urn:uuid: URIs, are accepted.
+	CheckEntryID Check = 1 << iota
+	// CheckAuthorURI checks that the author's URI belongs to the signer's
+	// domain.
+	CheckAuthorURI
+	// CheckObjectAuthor checks that the author of an activity's object belongs
+	// to the signer's domain. Shares of content from other domains fail this
+	// check.
+	CheckObjectAuthor
+)
+
+// DefaultChecks are the checks performed by a handler created with NewHandler.
+const DefaultChecks = CheckEntryID | CheckAuthorURI
+
+// String implements fmt.Stringer.
+func (c Check) String() string {
+	switch c {
+	case CheckEntryID:
+		return "entry ID"
+	case CheckAuthorURI:
+		return "author URI"
+	case CheckObjectAuthor:
+		return "object author"
+	default:
+		return fmt.Sprintf("check(%d)", int(c))
+	}
+}
+
+// A ConsistencyError is returned when a salmon fails a consistency check.
+type ConsistencyError struct {
+	// Check is the check that failed.
+	Check Check
+	// Value is the URI that doesn't belong to the signer's domain.
+	Value string
+	// AccountURI is the URI of the account that signed the salmon.
+	AccountURI string
+}
+
+// Error implements error.
+func (err *ConsistencyError) Error() string {
+	return fmt.Sprintf("salmon: %v %q doesn't belong to %v", err.Check, err.Value, err.AccountURI)
+}
+
+// uriDomain extracts a domain from an acct:, tag: or HTTP URI. It returns an
+// empty string if the URI doesn't contain a domain.
+func uriDomain(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return strings.ToLower(u.Hostname())
+	case "acct", "mailto":
+		parts := strings.SplitN(u.Opaque, "@", 2)
+		if len(parts) != 2 {
+			return ""
+		}
+		return strings.ToLower(parts[1])
+	case "tag":
+		// tag:example.com,2017:foo or tag:bob@example.com,2017:foo
+		entity := strings.SplitN(u.Opaque, ",", 2)[0]
+		if i := strings.LastIndex(entity, "@"); i >= 0 {
+			entity = entity[i+1:]
+		}
+		return strings.ToLower(entity)
+	default:
+		return ""
+	}
+}
+
+func personURI(p *activitystream.Person) string {
+	if uri := p.AccountURI(); uri != "" {
+		return uri
+	}
+	if p.URI != "" {
+		return p.URI
+	}
+	return p.ID
+}
+
+func checkEntry(entry *activitystream.Entry, accountURI string, checks Check) error {
+	domain := uriDomain(accountURI)
+
+	check := func(c Check, uri string) error {
+		if checks&c == 0 {
+			return nil
+		}
+		if d := uriDomain(uri); d == "" || d != domain {
+			return &ConsistencyError{Check: c, Value: uri, AccountURI: accountURI}
+		}
+		return nil
+	}
+
+	if uriDomain(entry.ID) != "" {
+		if err := check(CheckEntryID, entry.ID); err != nil {
+			return err
+		}
+	}
+	if entry.Author != nil && entry.Author.URI != "" {
+		if err := check(CheckAuthorURI, entry.Author.URI); err != nil {
+			return err
+		}
+	}
+	if entry.Object != nil && entry.Object.Author != nil {
+		if err := check(CheckObjectAuthor, personURI(entry.Object.Author)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
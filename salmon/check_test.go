@@ -0,0 +1,25 @@
+package salmon
+
+import (
+	"testing"
+)
+
+func TestURIDomain(t *testing.T) {
+	tests := map[string]string{
+		"acct:bob@example.com":                   "example.com",
+		"acct:bob@Example.COM":                   "example.com",
+		"https://example.com/users/bob":          "example.com",
+		"http://example.com:8080/notice/42":      "example.com",
+		"tag:example.com,2017:objectId=42":       "example.com",
+		"tag:bob@example.com,2017-04-23:note-42": "example.com",
+		"urn:uuid:60a76c80-d399-11d9-b93C":       "",
+		"bob@example.com":                        "",
+		"":                                       "",
+	}
+
+	for uri, want := range tests {
+		if got := uriDomain(uri); got != want {
+			t.Errorf("uriDomain(%q) = %q, want %q", uri, got, want)
+		}
+	}
+}
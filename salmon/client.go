@@ -0,0 +1,66 @@
+package salmon
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+)
+
+// An HTTPError is returned when a salmon endpoint replies with an HTTP error.
+// Its value is the HTTP status code.
+type HTTPError int
+
+// Error implements error.
+func (err HTTPError) Error() string {
+	return "salmon: HTTP request failed"
+}
+
+// A Client pushes salmons to remote endpoints.
+type Client struct {
+	// HTTPClient is the HTTP client used to send salmons. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Send pushes a magic envelope to a salmon endpoint.
+func (c *Client) Send(ctx context.Context, endpoint string, env *MagicEnv) error {
+	var b bytes.Buffer
+	if _, err := io.WriteString(&b, xml.Header); err != nil {
+		return err
+	}
+	if err := xml.NewEncoder(&b).Encode(env); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, &b)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/magic-envelope+xml")
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close() // We don't need the response body
+
+	if resp.StatusCode/100 != 2 {
+		return HTTPError(resp.StatusCode)
+	}
+	return nil
+}
+
+// Send pushes a magic envelope to a salmon endpoint with a default client.
+func Send(endpoint string, env *MagicEnv) error {
+	return new(Client).Send(context.Background(), endpoint, env)
+}
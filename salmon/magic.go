@@ -21,6 +21,7 @@ var (
 	errMalformedPublicKey   = errors.New("salmon: malformed public key")
 	errUnknownAlg           = errors.New("salmon: unknown signature algorithm")
 	errInvalidPublicKeyType = errors.New("salmon: invalid public key type")
+	errNoData               = errors.New("salmon: no data in envelope")
 )
 
 func decodeString(s string) ([]byte, error) {
@@ -120,6 +121,10 @@ func PublicKeyID(pk crypto.PublicKey) (string, error) {
 }
 
 func computeHash(env *MagicEnv) ([]byte, error) {
+	if env.Data == nil {
+		return nil, errNoData
+	}
+
 	mediaType := signatureEncodeToString([]byte(env.Data.Type))
 	encoding := signatureEncodeToString([]byte(env.Encoding))
 	alg := signatureEncodeToString([]byte(env.Alg))
@@ -133,8 +138,13 @@ func computeHash(env *MagicEnv) ([]byte, error) {
 }
 
 func sign(env *MagicEnv, priv crypto.PrivateKey) error {
-	switch priv := priv.(type) {
-	case *rsa.PrivateKey:
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return errUnknownKeyType
+	}
+
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
 		if env.Alg != "" && env.Alg != "RSA-SHA256" {
 			return errors.New("salmon: cannot sign an envelope with two different algorithms")
 		}
@@ -145,12 +155,14 @@ func sign(env *MagicEnv, priv crypto.PrivateKey) error {
 			return err
 		}
 
-		sigb, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hashed)
+		// For RSA keys, crypto.Signer uses PKCS #1 v1.5 unless PSS options are
+		// provided
+		sigb, err := signer.Sign(rand.Reader, hashed, crypto.SHA256)
 		if err != nil {
 			return err
 		}
 
-		keyid, err := PublicKeyID(&priv.PublicKey)
+		keyid, err := PublicKeyID(pub)
 		if err != nil {
 			return err
 		}
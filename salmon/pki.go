@@ -6,6 +6,7 @@ import (
 
 	"github.com/emersion/go-ostatus/xrd"
 	"github.com/emersion/go-ostatus/xrd/lrdd"
+	"github.com/emersion/go-ostatus/xrd/webfinger"
 )
 
 // ResourcePublicKey returns a resource's public key.
@@ -31,20 +32,61 @@ type PublicKeyBackend interface {
 	PublicKey(accountURI string) (crypto.PublicKey, error)
 }
 
-type publicKeyBackend struct{}
+// A DiscoverFunc retrieves the resource descriptor of an account.
+type DiscoverFunc func(accountURI string) (*xrd.Resource, error)
+
+// DiscoverWebFinger retrieves the resource descriptor of an account with
+// WebFinger.
+func DiscoverWebFinger(accountURI string) (*xrd.Resource, error) {
+	domain := uriDomain(accountURI)
+	if domain == "" {
+		return nil, errors.New("salmon: cannot extract domain from account URI")
+	}
+	return webfinger.Get(domain, accountURI)
+}
+
+// DiscoverLRDD retrieves the resource descriptor of an account with LRDD.
+func DiscoverLRDD(accountURI string) (*xrd.Resource, error) {
+	// TODO: if err == lrdd.ErrNoHost, directly fetch accountURI (see section 8.2.3)
+	return lrdd.Get(accountURI)
+}
+
+type publicKeyBackend struct {
+	discover []DiscoverFunc
+}
 
 // NewPublicKeyBackend returns a basic PublicKeyBackend that queries public keys
 // with LRDD.
 func NewPublicKeyBackend() PublicKeyBackend {
-	return new(publicKeyBackend)
+	return NewDiscoveryPublicKeyBackend(DiscoverLRDD)
 }
 
-func (be *publicKeyBackend) PublicKey(accountURI string) (crypto.PublicKey, error) {
-	resource, err := lrdd.Get(accountURI)
-	// TODO: if err == lrdd.ErrNoHost, directly fetch accountURI (see section 8.2.3)
-	if err != nil {
-		return nil, err
+// NewDiscoveryPublicKeyBackend returns a PublicKeyBackend that tries each
+// discovery function in order, until one of them returns a resource with a
+// public key. If no discovery function is provided, WebFinger is tried first,
+// then LRDD. If all of them fail, the returned error wraps each failure.
+func NewDiscoveryPublicKeyBackend(discover ...DiscoverFunc) PublicKeyBackend {
+	if len(discover) == 0 {
+		discover = []DiscoverFunc{DiscoverWebFinger, DiscoverLRDD}
 	}
+	return &publicKeyBackend{discover}
+}
+
+func (be *publicKeyBackend) PublicKey(accountURI string) (crypto.PublicKey, error) {
+	var errs []error
+	for _, discover := range be.discover {
+		resource, err := discover(accountURI)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
 
-	return ResourcePublicKey(resource)
+		pub, err := ResourcePublicKey(resource)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return pub, nil
+	}
+	return nil, errors.Join(errs...)
 }
@@ -0,0 +1,181 @@
+package salmon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-ostatus/xrd"
+	"github.com/emersion/go-ostatus/xrd/hostmeta"
+	"github.com/emersion/go-ostatus/xrd/lrdd"
+	"github.com/emersion/go-ostatus/xrd/webfinger"
+)
+
+func TestDiscoveryPublicKeyBackend(t *testing.T) {
+	var calls []string
+	failing := func(accountURI string) (*xrd.Resource, error) {
+		calls = append(calls, "failing")
+		return nil, errors.New("discovery failed")
+	}
+	noKey := func(accountURI string) (*xrd.Resource, error) {
+		calls = append(calls, "noKey")
+		return &xrd.Resource{Subject: accountURI}, nil
+	}
+	withKey := func(accountURI string) (*xrd.Resource, error) {
+		calls = append(calls, "withKey")
+		return &xrd.Resource{
+			Subject: accountURI,
+			Links: []*xrd.Link{
+				{Rel: RelMagicPublicKey, Href: testDataURL},
+			},
+		}, nil
+	}
+
+	be := NewDiscoveryPublicKeyBackend(failing, noKey, withKey)
+	pub, err := be.PublicKey(testAccountURI)
+	if err != nil {
+		t.Fatal("PublicKey() =", err)
+	}
+	if !reflect.DeepEqual(pub, testPublicKey) {
+		t.Errorf("PublicKey() = %v, want %v", pub, testPublicKey)
+	}
+	if want := []string{"failing", "noKey", "withKey"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Expected calls %v, got %v", want, calls)
+	}
+
+	calls = nil
+	be = NewDiscoveryPublicKeyBackend(withKey, failing)
+	if _, err := be.PublicKey(testAccountURI); err != nil {
+		t.Fatal("PublicKey() =", err)
+	}
+	if want := []string{"withKey"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Expected calls %v, got %v", want, calls)
+	}
+
+	be = NewDiscoveryPublicKeyBackend(noKey, failing)
+	if _, err := be.PublicKey(testAccountURI); err == nil {
+		t.Error("PublicKey() = nil, want an error")
+	}
+}
+
+type testResourceBackend struct{}
+
+func (testResourceBackend) Resource(uri string, rel []string) (*xrd.Resource, error) {
+	if uri != testAccountURI {
+		return nil, xrd.ErrNoSuchResource
+	}
+	return &xrd.Resource{
+		Subject: uri,
+		Links: []*xrd.Link{
+			{Rel: RelMagicPublicKey, Href: testDataURL},
+		},
+	}, nil
+}
+
+type testLRDDBackend struct{}
+
+func (testLRDDBackend) Resource(req *http.Request) (*xrd.Resource, error) {
+	return testResourceBackend{}.Resource(req.URL.Query().Get("uri"), nil)
+}
+
+// startDiscoveryServer starts a TLS server for example.com, with the given
+// discovery endpoints. It replaces http.DefaultTransport until the test ends.
+func startDiscoveryServer(t *testing.T, webFinger, hostMeta bool) {
+	mux := http.NewServeMux()
+	if webFinger {
+		mux.Handle(webfinger.WellKnownPath, webfinger.NewHandler(testResourceBackend{}))
+	}
+	if hostMeta {
+		mux.Handle(hostmeta.WellKnownPath, hostmeta.NewHandler(&xrd.Resource{
+			Links: []*xrd.Link{
+				{Rel: lrdd.Rel, Template: "https://example.com/lrdd?uri={uri}"},
+			},
+		}))
+		mux.Handle("/lrdd", xrd.NewHandler(testLRDDBackend{}))
+	}
+
+	s := httptest.NewTLSServer(mux)
+	t.Cleanup(s.Close)
+
+	// The test server certificate is valid for example.com
+	transport := s.Client().Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, s.Listener.Addr().String())
+	}
+
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = transport
+	t.Cleanup(func() {
+		http.DefaultTransport = defaultTransport
+	})
+}
+
+func TestDiscoverWebFinger(t *testing.T) {
+	startDiscoveryServer(t, true, false)
+
+	resource, err := DiscoverWebFinger(testAccountURI)
+	if err != nil {
+		t.Fatal("DiscoverWebFinger() =", err)
+	}
+	pub, err := ResourcePublicKey(resource)
+	if err != nil {
+		t.Fatal("ResourcePublicKey() =", err)
+	}
+	if !reflect.DeepEqual(pub, testPublicKey) {
+		t.Errorf("ResourcePublicKey() = %v, want %v", pub, testPublicKey)
+	}
+
+	if _, err := DiscoverWebFinger("acct:alice@example.com"); err == nil {
+		t.Error("DiscoverWebFinger(unknown account) = nil, want an error")
+	}
+}
+
+func TestDiscoverLRDD(t *testing.T) {
+	startDiscoveryServer(t, false, true)
+
+	resource, err := DiscoverLRDD(testAccountURI)
+	if err != nil {
+		t.Fatal("DiscoverLRDD() =", err)
+	}
+	pub, err := ResourcePublicKey(resource)
+	if err != nil {
+		t.Fatal("ResourcePublicKey() =", err)
+	}
+	if !reflect.DeepEqual(pub, testPublicKey) {
+		t.Errorf("ResourcePublicKey() = %v, want %v", pub, testPublicKey)
+	}
+}
+
+func TestDiscoveryPublicKeyBackend_fallback(t *testing.T) {
+	startDiscoveryServer(t, false, true)
+
+	be := NewDiscoveryPublicKeyBackend()
+	pub, err := be.PublicKey(testAccountURI)
+	if err != nil {
+		t.Fatal("PublicKey() =", err)
+	}
+	if !reflect.DeepEqual(pub, testPublicKey) {
+		t.Errorf("PublicKey() = %v, want %v", pub, testPublicKey)
+	}
+}
+
+func TestDiscoveryPublicKeyBackend_errors(t *testing.T) {
+	webFingerErr := errors.New("webfinger failed")
+	lrddErr := errors.New("lrdd failed")
+
+	be := NewDiscoveryPublicKeyBackend(func(accountURI string) (*xrd.Resource, error) {
+		return nil, webFingerErr
+	}, func(accountURI string) (*xrd.Resource, error) {
+		return nil, lrddErr
+	})
+
+	_, err := be.PublicKey(testAccountURI)
+	if !errors.Is(err, webFingerErr) || !errors.Is(err, lrddErr) {
+		t.Errorf("PublicKey() = %v, want both discovery errors", err)
+	}
+}
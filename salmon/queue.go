@@ -0,0 +1,283 @@
+package salmon
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-ostatus/internal/delivery"
+)
+
+// A Delivery is a salmon waiting to be pushed to an endpoint.
+type Delivery struct {
+	// ID uniquely identifies the delivery.
+	ID string
+	// Endpoint is the URL of the salmon endpoint.
+	Endpoint string
+	// Env is the magic envelope to push.
+	Env *MagicEnv
+	// Attempts is the number of failed attempts so far.
+	Attempts int
+	// NextAttempt is the time of the next attempt.
+	NextAttempt time.Time
+	// LastError describes why the last attempt failed.
+	LastError string
+}
+
+// A DeliveryStore persists the deliveries of a Queue.
+type DeliveryStore interface {
+	// List returns all pending deliveries.
+	List() ([]*Delivery, error)
+	// Put creates or updates a delivery.
+	Put(d *Delivery) error
+	// Delete removes a delivery.
+	Delete(id string) error
+}
+
+// NewMemoryDeliveryStore returns a DeliveryStore that keeps deliveries in
+// memory. Pending deliveries are lost when the process exits.
+func NewMemoryDeliveryStore() DeliveryStore {
+	return delivery.NewMemoryStore(func(d *Delivery) string {
+		return d.ID
+	})
+}
+
+// Default Queue parameters.
+const (
+	DefaultMaxAttempts = 10
+	DefaultMinBackoff  = 30 * time.Second
+	DefaultMaxBackoff  = 6 * time.Hour
+	DefaultMaxPerHost  = 2
+)
+
+var errQueueClosed = errors.New("salmon: queue closed")
+
+// A Queue pushes salmons to remote endpoints, and retries failed deliveries
+// with an exponential backoff.
+type Queue struct {
+	// Client is used to push salmons.
+	Client *Client
+	// MaxAttempts is the maximum number of attempts for a delivery.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the delay between two attempts. The delay
+	// doubles after each failed attempt, and is randomized to avoid retrying
+	// many deliveries at the same time. If MinBackoff is not positive,
+	// DefaultMinBackoff is used.
+	MinBackoff, MaxBackoff time.Duration
+	// MaxPerHost is the maximum number of concurrent requests to a single host.
+	// If zero, there is no limit.
+	MaxPerHost int
+	// DeadLetter specifies an optional callback function that is called when a
+	// delivery is given up, either because it failed too many times or because
+	// the endpoint rejected it.
+	DeadLetter func(d *Delivery, err error)
+
+	store  DeliveryStore
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	locker sync.Mutex
+	closed bool
+	timers map[string]*time.Timer
+	hosts  map[string]chan struct{}
+}
+
+// NewQueue creates a new delivery queue that persists pending deliveries in
+// store. Start must be called to resume deliveries left by a previous run.
+func NewQueue(store DeliveryStore) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		Client:      new(Client),
+		MaxAttempts: DefaultMaxAttempts,
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		MaxPerHost:  DefaultMaxPerHost,
+		store:       store,
+		ctx:         ctx,
+		cancel:      cancel,
+		timers:      make(map[string]*time.Timer),
+		hosts:       make(map[string]chan struct{}),
+	}
+}
+
+// Start schedules the deliveries left in the store by a previous run.
+// Deliveries that are already scheduled, e.g. by Push or by a previous call to
+// Start, are left untouched.
+func (q *Queue) Start() error {
+	l, err := q.store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range l {
+		q.schedule(d)
+	}
+	return nil
+}
+
+// Push queues a magic envelope for delivery to a salmon endpoint.
+func (q *Queue) Push(endpoint string, env *MagicEnv) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	d := &Delivery{
+		ID:          encodeToString(b),
+		Endpoint:    endpoint,
+		Env:         env,
+		NextAttempt: time.Now(),
+	}
+	if err := q.store.Put(d); err != nil {
+		return err
+	}
+
+	if !q.schedule(d) {
+		return errQueueClosed
+	}
+	return nil
+}
+
+// Close stops the queue and waits for in-flight requests to finish. Pending
+// deliveries are kept in the store.
+func (q *Queue) Close() error {
+	q.locker.Lock()
+	q.closed = true
+	for id, t := range q.timers {
+		if t.Stop() {
+			q.wg.Done()
+		}
+		delete(q.timers, id)
+	}
+	q.locker.Unlock()
+
+	q.cancel()
+	q.wg.Wait()
+	return nil
+}
+
+// schedule arranges for d to be delivered at d.NextAttempt. It does nothing if
+// the delivery is already scheduled or in flight.
+func (q *Queue) schedule(d *Delivery) bool {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+
+	if q.closed {
+		return false
+	}
+	if _, ok := q.timers[d.ID]; ok {
+		return true
+	}
+
+	q.wg.Add(1)
+	q.timers[d.ID] = time.AfterFunc(time.Until(d.NextAttempt), func() {
+		defer q.wg.Done()
+
+		retry := q.deliver(d)
+
+		// The timer is only removed once the attempt is over, so that the
+		// delivery cannot be scheduled twice while in flight
+		q.locker.Lock()
+		delete(q.timers, d.ID)
+		q.locker.Unlock()
+
+		if retry {
+			q.schedule(d)
+		}
+	})
+	return true
+}
+
+func (q *Queue) hostSemaphore(endpoint string) chan struct{} {
+	if q.MaxPerHost <= 0 {
+		return nil
+	}
+
+	host := delivery.Host(endpoint)
+
+	q.locker.Lock()
+	defer q.locker.Unlock()
+
+	sem, ok := q.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, q.MaxPerHost)
+		q.hosts[host] = sem
+	}
+	return sem
+}
+
+func (q *Queue) send(d *Delivery) error {
+	if sem := q.hostSemaphore(d.Endpoint); sem != nil {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		case <-q.ctx.Done():
+			return q.ctx.Err()
+		}
+	}
+
+	return q.Client.Send(q.ctx, d.Endpoint, d.Env)
+}
+
+// deliver makes an attempt to push d. It returns true if the delivery needs to
+// be retried.
+func (q *Queue) deliver(d *Delivery) bool {
+	err := q.send(d)
+	if err == nil {
+		q.store.Delete(d.ID)
+		return false
+	}
+
+	if q.ctx.Err() != nil {
+		// The queue has been closed, the delivery stays in the store
+		return false
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	if d.Attempts >= q.MaxAttempts || isPermanent(err) {
+		q.store.Delete(d.ID)
+		if q.DeadLetter != nil {
+			q.DeadLetter(d, err)
+		}
+		return false
+	}
+
+	d.NextAttempt = time.Now().Add(q.backoff(d.Attempts))
+	// If this fails, the delivery is still retried but won't survive a restart
+	q.store.Put(d)
+	return true
+}
+
+// backoff returns the delay before the next attempt, after n failed attempts.
+func (q *Queue) backoff(n int) time.Duration {
+	d := q.MinBackoff
+	if d <= 0 {
+		// Never retry in a busy loop
+		d = DefaultMinBackoff
+	}
+	max := q.MaxBackoff
+	if max < d {
+		max = d
+	}
+
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	// Pick a random delay in [d/2, d]
+	return d/2 + time.Duration(mathrand.Int63n(int64(d/2)+1))
+}
+
+// isPermanent checks if retrying a failed delivery is pointless.
+func isPermanent(err error) bool {
+	code, ok := err.(HTTPError)
+	return ok && delivery.IsPermanent(int(code))
+}
@@ -0,0 +1,236 @@
+package salmon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testQueue(store DeliveryStore) *Queue {
+	q := NewQueue(store)
+	q.MinBackoff = time.Millisecond
+	q.MaxBackoff = 5 * time.Millisecond
+	return q
+}
+
+func waitEmpty(t *testing.T, store DeliveryStore) {
+	for i := 0; i < 200; i++ {
+		l, err := store.List()
+		if err != nil {
+			t.Fatal("List() =", err)
+		}
+		if len(l) == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Deliveries are still pending")
+}
+
+func testMagicEnv(t *testing.T) *MagicEnv {
+	env, err := CreateMagicEnv("application/atom+xml", []byte(testReply), testKey(t))
+	if err != nil {
+		t.Fatalf("CreateMagicEnv() = %v", err)
+	}
+	return env
+}
+
+func TestQueue(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Type") != "application/magic-envelope+xml" {
+			t.Errorf("Invalid content type: %v", req.Header.Get("Content-Type"))
+		}
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		resp.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	store := NewMemoryDeliveryStore()
+	q := testQueue(store)
+	q.DeadLetter = func(d *Delivery, err error) {
+		t.Errorf("Unexpected dead letter: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.Push(s.URL, testMagicEnv(t)); err != nil {
+		t.Fatal("Push() =", err)
+	}
+
+	waitEmpty(t, store)
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("Expected 3 requests, got %v", n)
+	}
+}
+
+func TestQueue_deadLetter(t *testing.T) {
+	tests := []struct {
+		code     int
+		attempts int
+	}{
+		{http.StatusInternalServerError, 3},
+		{http.StatusBadRequest, 1},
+	}
+
+	for _, test := range tests {
+		var requests int32
+		s := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			resp.WriteHeader(test.code)
+		}))
+
+		store := NewMemoryDeliveryStore()
+		q := testQueue(store)
+		q.MaxAttempts = 3
+
+		done := make(chan *Delivery, 1)
+		q.DeadLetter = func(d *Delivery, err error) {
+			if err != HTTPError(test.code) {
+				t.Errorf("DeadLetter(err = %v), want %v", err, HTTPError(test.code))
+			}
+			done <- d
+		}
+
+		if err := q.Push(s.URL, testMagicEnv(t)); err != nil {
+			t.Fatal("Push() =", err)
+		}
+
+		select {
+		case d := <-done:
+			if d.Attempts != test.attempts {
+				t.Errorf("%v: expected %v attempts, got %v", test.code, test.attempts, d.Attempts)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%v: delivery not given up", test.code)
+		}
+
+		q.Close()
+		s.Close()
+
+		if n := int(atomic.LoadInt32(&requests)); n != test.attempts {
+			t.Errorf("%v: expected %v requests, got %v", test.code, test.attempts, n)
+		}
+		waitEmpty(t, store)
+	}
+}
+
+func TestQueue_Start(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		resp.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	store := NewMemoryDeliveryStore()
+	store.Put(&Delivery{
+		ID:          "pending",
+		Endpoint:    s.URL,
+		Env:         testMagicEnv(t),
+		Attempts:    2,
+		NextAttempt: time.Now(),
+	})
+
+	q := testQueue(store)
+	defer q.Close()
+	if err := q.Start(); err != nil {
+		t.Fatal("Start() =", err)
+	}
+
+	waitEmpty(t, store)
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("Expected 1 request, got %v", n)
+	}
+}
+
+func TestQueue_maxPerHost(t *testing.T) {
+	var locker sync.Mutex
+	var current, max int
+	s := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		locker.Lock()
+		current++
+		if current > max {
+			max = current
+		}
+		locker.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		locker.Lock()
+		current--
+		locker.Unlock()
+
+		resp.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	store := NewMemoryDeliveryStore()
+	q := testQueue(store)
+	q.MaxPerHost = 2
+	defer q.Close()
+
+	env := testMagicEnv(t)
+	for i := 0; i < 8; i++ {
+		if err := q.Push(s.URL, env); err != nil {
+			t.Fatal("Push() =", err)
+		}
+	}
+
+	waitEmpty(t, store)
+	if max > q.MaxPerHost {
+		t.Errorf("Expected at most %v concurrent requests, got %v", q.MaxPerHost, max)
+	}
+}
+
+func TestQueue_Start_twice(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(10 * time.Millisecond)
+		resp.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	store := NewMemoryDeliveryStore()
+	q := testQueue(store)
+	defer q.Close()
+
+	if err := q.Push(s.URL, testMagicEnv(t)); err != nil {
+		t.Fatal("Push() =", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := q.Start(); err != nil {
+			t.Fatal("Start() =", err)
+		}
+	}
+
+	waitEmpty(t, store)
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("Expected 1 request, got %v", n)
+	}
+}
+
+func TestQueue_backoff(t *testing.T) {
+	q := NewQueue(NewMemoryDeliveryStore())
+	defer q.Close()
+
+	q.MinBackoff = 0
+	q.MaxBackoff = 0
+	if d := q.backoff(1); d < DefaultMinBackoff/2 || d > DefaultMinBackoff {
+		t.Errorf("backoff(1) = %v, want a delay in [%v, %v]", d, DefaultMinBackoff/2, DefaultMinBackoff)
+	}
+
+	q.MinBackoff = time.Second
+	q.MaxBackoff = 3 * time.Second
+	for n := 1; n <= 5; n++ {
+		if d := q.backoff(n); d <= 0 || d > q.MaxBackoff {
+			t.Errorf("backoff(%v) = %v, want a delay in ]0, %v]", n, d, q.MaxBackoff)
+		}
+	}
+}
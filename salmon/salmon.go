@@ -23,7 +23,8 @@ type MagicEnv struct {
 	Sig      []*MagicSig `xml:"sig"`
 }
 
-// CreateMagicEnv creates a new magic envelope.
+// CreateMagicEnv creates a new magic envelope. priv must be a crypto.Signer
+// with an RSA public key, e.g. a *rsa.PrivateKey.
 func CreateMagicEnv(mediaType string, data []byte, priv crypto.PrivateKey) (*MagicEnv, error) {
 	env := &MagicEnv{
 		Data: &MagicData{
@@ -40,6 +41,13 @@ func CreateMagicEnv(mediaType string, data []byte, priv crypto.PrivateKey) (*Mag
 	return env, nil
 }
 
+// Sign adds a signature made with priv to the envelope. It can be used to sign
+// an envelope with several keys. priv must be a crypto.Signer with an RSA
+// public key.
+func (env *MagicEnv) Sign(priv crypto.PrivateKey) error {
+	return sign(env, priv)
+}
+
 type magicEnvJSON struct {
 	*MagicData
 	Encoding string      `json:"encoding"`
@@ -74,6 +82,10 @@ func (env *MagicEnv) MarshalJSON() ([]byte, error) {
 // UnverifiedData returns this envelope's message, without checking the
 // signature.
 func (env *MagicEnv) UnverifiedData() ([]byte, error) {
+	if env.Data == nil {
+		return nil, errNoData
+	}
+
 	switch env.Encoding {
 	case "base64url":
 		return decodeString(env.Data.Value)
@@ -84,20 +96,27 @@ func (env *MagicEnv) UnverifiedData() ([]byte, error) {
 
 // Verify checks that the envelope is signed with pub.
 func (env *MagicEnv) Verify(pub crypto.PublicKey) error {
+	_, err := env.verify(pub)
+	return err
+}
+
+// verify checks that the envelope is signed with pub and returns the matching
+// signature.
+func (env *MagicEnv) verify(pub crypto.PublicKey) (*MagicSig, error) {
 	if len(env.Sig) == 0 {
-		return errors.New("salmon: no signature in envelope")
+		return nil, errors.New("salmon: no signature in envelope")
 	}
 
 	var err error
 	for _, sig := range env.Sig {
 		if err = verify(env, pub, sig.Value); err == nil {
-			return nil
+			return sig, nil
 		} else if err != rsa.ErrVerification && err != errInvalidPublicKeyType {
 			break
 		}
 	}
 
-	return err
+	return nil, err
 }
 
 // A MagicaData contains a type and a value.
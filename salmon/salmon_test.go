@@ -1,9 +1,12 @@
 package salmon
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rsa"
-	"encoding/xml"
 	"encoding/json"
+	"encoding/xml"
 	"math/rand"
 	"strings"
 	"testing"
@@ -79,6 +82,91 @@ func TestMagicEnv(t *testing.T) {
 	}
 }
 
+// opaqueSigner hides the concrete type of a crypto.Signer, like a KMS-backed
+// key would.
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+func TestMagicEnv_signer(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 512)
+	if err != nil {
+		t.Fatal("Cannot generate private key:", err)
+	}
+
+	env, err := CreateMagicEnv("application/atom+xml", []byte(testReply), opaqueSigner{priv})
+	if err != nil {
+		t.Fatalf("CreateMagicEnv() = %v", err)
+	}
+
+	if err := env.Verify(&priv.PublicKey); err != nil {
+		t.Errorf("Verify(correct key) = %v", err)
+	}
+
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.New(rand.NewSource(0)))
+	if err != nil {
+		t.Fatal("Cannot generate private key:", err)
+	}
+	if _, err := CreateMagicEnv("application/atom+xml", []byte(testReply), ecPriv); err == nil {
+		t.Error("CreateMagicEnv(ECDSA key) = nil, want an error")
+	}
+}
+
+func TestMagicEnv_Sign(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	priv1, err := rsa.GenerateKey(r, 512)
+	if err != nil {
+		t.Fatal("Cannot generate private key:", err)
+	}
+	priv2, err := rsa.GenerateKey(r, 512)
+	if err != nil {
+		t.Fatal("Cannot generate private key:", err)
+	}
+
+	env, err := CreateMagicEnv("application/atom+xml", []byte(testReply), priv1)
+	if err != nil {
+		t.Fatalf("CreateMagicEnv() = %v", err)
+	}
+	if err := env.Sign(opaqueSigner{priv2}); err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+
+	if len(env.Sig) != 2 {
+		t.Fatalf("Expected 2 signatures, got %v", len(env.Sig))
+	}
+	if env.Sig[0].KeyID == env.Sig[1].KeyID {
+		t.Error("Expected different key IDs")
+	}
+
+	if err := env.Verify(&priv1.PublicKey); err != nil {
+		t.Errorf("Verify(first key) = %v", err)
+	}
+	if err := env.Verify(&priv2.PublicKey); err != nil {
+		t.Errorf("Verify(second key) = %v", err)
+	}
+	if err := env.Verify(testPublicKey); err == nil {
+		t.Errorf("Verify(incorrect key) = %v", err)
+	}
+}
+
+func TestMagicEnv_Sign_noData(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 512)
+	if err != nil {
+		t.Fatal("Cannot generate private key:", err)
+	}
+
+	env := &MagicEnv{Encoding: "base64url"}
+	if err := env.Sign(priv); err == nil {
+		t.Error("Sign(envelope without data) = nil, want an error")
+	}
+	if len(env.Sig) != 0 {
+		t.Errorf("Expected no signature, got %v", len(env.Sig))
+	}
+	if _, err := env.UnverifiedData(); err == nil {
+		t.Error("UnverifiedData(envelope without data) = nil, want an error")
+	}
+}
+
 func TestMagicEnv_xml(t *testing.T) {
 	r := strings.NewReader(testMagicEnvXML)
 
@@ -2,8 +2,11 @@ package salmon
 
 import (
 	"bytes"
-	"encoding/xml"
+	"context"
+	"crypto"
 	"encoding/json"
+	"encoding/xml"
+	"log/slog"
 	"net/http"
 
 	"github.com/emersion/go-ostatus/activitystream"
@@ -17,12 +20,54 @@ type Backend interface {
 	Notify(*activitystream.Entry) error
 }
 
-type handler struct {
+// A Notification contains a verified salmon and information about how it was
+// received.
+type Notification struct {
+	// Entry is the salmon's payload.
+	Entry *activitystream.Entry
+	// AccountURI is the URI of the account whose key signed the envelope.
+	AccountURI string
+	// PublicKey is the key that verified the envelope.
+	PublicKey crypto.PublicKey
+	// KeyID is the identifier of the key that verified the envelope.
+	KeyID string
+	// Env is the magic envelope, as received.
+	Env *MagicEnv
+	// Request is the HTTP request that carried the salmon.
+	Request *http.Request
+}
+
+// A NotificationBackend is a Backend that wants to know more about received
+// salmons. If a Backend implements NotificationBackend, HandleNotification is
+// called instead of Notify.
+type NotificationBackend interface {
+	Backend
+
+	// HandleNotification is called when a salmon is pushed to the endpoint.
+	HandleNotification(ctx context.Context, n *Notification) error
+}
+
+// A Handler is a salmon endpoint.
+type Handler struct {
+	// Checks is the set of consistency checks performed on incoming salmons.
+	Checks Check
+	// Logger receives rejected salmons and backend failures. Log records have
+	// account and err attributes where relevant. If nil, slog.Default() is
+	// used.
+	Logger *slog.Logger
+
 	be Backend
 }
 
+func (h *Handler) logger() *slog.Logger {
+	if h.Logger == nil {
+		return slog.Default()
+	}
+	return h.Logger
+}
+
 // ServeHTTP implements http.Handler.
-func (h *handler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+func (h *Handler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
 	if req.Method != http.MethodPost {
@@ -74,16 +119,41 @@ func (h *handler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 
 	pub, err := h.be.PublicKey(accountURI)
 	if err != nil {
+		h.logger().Warn("cannot get salmon public key", "account", accountURI, "err", err)
 		http.Error(resp, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := env.Verify(pub); err != nil {
+	if _, err := env.verify(pub); err != nil {
+		h.logger().Warn("invalid salmon signature", "account", accountURI, "err", err)
 		http.Error(resp, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := h.be.Notify(entry); err != nil {
+	if err := checkEntry(entry, accountURI, h.Checks); err != nil {
+		h.logger().Warn("inconsistent salmon", "account", accountURI, "err", err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if be, ok := h.be.(NotificationBackend); ok {
+		// The key_id attribute in the envelope is chosen by the sender, so
+		// report the ID of the key that actually verified the signature.
+		keyID, _ := PublicKeyID(pub)
+
+		err = be.HandleNotification(req.Context(), &Notification{
+			Entry:      entry,
+			AccountURI: accountURI,
+			PublicKey:  pub,
+			KeyID:      keyID,
+			Env:        env,
+			Request:    req,
+		})
+	} else {
+		err = h.be.Notify(entry)
+	}
+	if err != nil {
+		h.logger().Error("cannot handle salmon", "account", accountURI, "err", err)
 		http.Error(resp, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -91,7 +161,14 @@ func (h *handler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	resp.WriteHeader(http.StatusAccepted)
 }
 
-// NewHandler creates a new salmon endpoint.
-func NewHandler(be Backend) http.Handler {
-	return &handler{be}
+// NewHandler creates a new salmon endpoint. DefaultChecks are enabled: salmons
+// whose entry ID or author URI belong to another domain than the signer's
+// account are rejected. Deployments serving accounts and entries from
+// different domains need to set Handler.Checks accordingly.
+func NewHandler(be Backend) *Handler {
+	return &Handler{
+		Checks: DefaultChecks,
+		Logger: slog.Default(),
+		be:     be,
+	}
 }
@@ -0,0 +1,309 @@
+package salmon
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emersion/go-ostatus/activitystream"
+)
+
+const testAccountURI = "acct:bob@example.com"
+
+type testBackend struct {
+	pub      crypto.PublicKey
+	notified []*activitystream.Entry
+}
+
+func (be *testBackend) PublicKey(accountURI string) (crypto.PublicKey, error) {
+	if accountURI != testAccountURI {
+		return nil, errors.New("no such account")
+	}
+	return be.pub, nil
+}
+
+func (be *testBackend) Notify(entry *activitystream.Entry) error {
+	be.notified = append(be.notified, entry)
+	return nil
+}
+
+type testNotificationBackend struct {
+	testBackend
+	notifications []*Notification
+}
+
+func (be *testNotificationBackend) HandleNotification(ctx context.Context, n *Notification) error {
+	if ctx == nil {
+		return errors.New("nil context")
+	}
+	be.notifications = append(be.notifications, n)
+	return nil
+}
+
+func testKey(t *testing.T) *rsa.PrivateKey {
+	// Generate an insecure test key - we don't care
+	priv, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 512)
+	if err != nil {
+		t.Fatal("Cannot generate private key:", err)
+	}
+	return priv
+}
+
+func pushSalmon(t *testing.T, h http.Handler, entry *activitystream.Entry, priv crypto.PrivateKey) *httptest.ResponseRecorder {
+	var b bytes.Buffer
+	if err := entry.WriteTo(&b); err != nil {
+		t.Fatal("Cannot write entry:", err)
+	}
+
+	env, err := CreateMagicEnv("application/atom+xml", b.Bytes(), priv)
+	if err != nil {
+		t.Fatalf("CreateMagicEnv() = %v", err)
+	}
+
+	return pushEnv(t, h, env)
+}
+
+func pushEnv(t *testing.T, h http.Handler, env *MagicEnv) *httptest.ResponseRecorder {
+	var b bytes.Buffer
+	if err := xml.NewEncoder(&b).Encode(env); err != nil {
+		t.Fatal("Cannot encode envelope:", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/salmon", &b)
+	req.Header.Set("Content-Type", "application/magic-envelope+xml")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func testEntry() *activitystream.Entry {
+	return &activitystream.Entry{
+		ID:    "tag:example.com,2017:note-42",
+		Title: "Salmon swim upstream!",
+		Author: &activitystream.Person{
+			URI:  testAccountURI,
+			Name: "bob",
+		},
+		ObjectType: activitystream.ObjectNote,
+		Verb:       activitystream.VerbPost,
+	}
+}
+
+func TestHandler(t *testing.T) {
+	priv := testKey(t)
+	be := &testBackend{pub: &priv.PublicKey}
+
+	w := pushSalmon(t, NewHandler(be), testEntry(), priv)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("ServeHTTP() = %v %v", w.Code, w.Body.String())
+	}
+	if len(be.notified) != 1 {
+		t.Fatalf("Expected one notification, got %v", len(be.notified))
+	}
+	if be.notified[0].ID != testEntry().ID {
+		t.Errorf("Invalid entry ID: expected %v but got %v", testEntry().ID, be.notified[0].ID)
+	}
+}
+
+func TestHandler_notificationBackend(t *testing.T) {
+	priv := testKey(t)
+	be := &testNotificationBackend{testBackend: testBackend{pub: &priv.PublicKey}}
+
+	w := pushSalmon(t, NewHandler(be), testEntry(), priv)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("ServeHTTP() = %v %v", w.Code, w.Body.String())
+	}
+	if len(be.notified) != 0 {
+		t.Errorf("Expected Notify not to be called, got %v calls", len(be.notified))
+	}
+	if len(be.notifications) != 1 {
+		t.Fatalf("Expected one notification, got %v", len(be.notifications))
+	}
+
+	n := be.notifications[0]
+	if n.AccountURI != testAccountURI {
+		t.Errorf("Invalid account URI: expected %v but got %v", testAccountURI, n.AccountURI)
+	}
+	if keyID, _ := PublicKeyID(&priv.PublicKey); n.KeyID != keyID {
+		t.Errorf("Invalid key ID: expected %v but got %v", keyID, n.KeyID)
+	}
+	if n.Env == nil || len(n.Env.Sig) != 1 {
+		t.Errorf("Invalid envelope: %+v", n.Env)
+	}
+	if n.Request == nil {
+		t.Error("Expected a request")
+	}
+	if n.Entry.ID != testEntry().ID {
+		t.Errorf("Invalid entry ID: expected %v but got %v", testEntry().ID, n.Entry.ID)
+	}
+}
+
+func TestHandler_forgedKeyID(t *testing.T) {
+	priv := testKey(t)
+	be := &testNotificationBackend{testBackend: testBackend{pub: &priv.PublicKey}}
+
+	var b bytes.Buffer
+	if err := testEntry().WriteTo(&b); err != nil {
+		t.Fatal("Cannot write entry:", err)
+	}
+	env, err := CreateMagicEnv("application/atom+xml", b.Bytes(), priv)
+	if err != nil {
+		t.Fatalf("CreateMagicEnv() = %v", err)
+	}
+	env.Sig[0].KeyID = testKeyID
+
+	w := pushEnv(t, NewHandler(be), env)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("ServeHTTP() = %v %v", w.Code, w.Body.String())
+	}
+	if len(be.notifications) != 1 {
+		t.Fatalf("Expected one notification, got %v", len(be.notifications))
+	}
+
+	keyID, _ := PublicKeyID(&priv.PublicKey)
+	if n := be.notifications[0]; n.KeyID != keyID {
+		t.Errorf("Invalid key ID: expected %v but got %v", keyID, n.KeyID)
+	}
+}
+
+func TestHandler_invalidSignature(t *testing.T) {
+	priv := testKey(t)
+	be := &testBackend{pub: testPublicKey}
+
+	var logs bytes.Buffer
+	h := NewHandler(be)
+	h.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+	w := pushSalmon(t, h, testEntry(), priv)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+	if len(be.notified) != 0 {
+		t.Errorf("Expected no notification, got %v", len(be.notified))
+	}
+
+	var record struct {
+		Msg     string
+		Account string
+	}
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatal("Invalid log record:", err)
+	}
+	if record.Msg != "invalid salmon signature" || record.Account != testAccountURI {
+		t.Errorf("Invalid log record: %v", logs.String())
+	}
+}
+
+func TestHandler_nilLogger(t *testing.T) {
+	priv := testKey(t)
+	h := NewHandler(&testBackend{pub: testPublicKey})
+	h.Logger = nil
+
+	if w := pushSalmon(t, h, testEntry(), priv); w.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandler_consistency(t *testing.T) {
+	priv := testKey(t)
+
+	tests := []struct {
+		name   string
+		checks Check
+		modify func(entry *activitystream.Entry)
+		err    Check
+	}{
+		{
+			name:   "entry ID",
+			checks: DefaultChecks,
+			modify: func(entry *activitystream.Entry) {
+				entry.ID = "tag:example.org,2017:note-42"
+			},
+			err: CheckEntryID,
+		},
+		{
+			name:   "opaque entry ID",
+			checks: DefaultChecks,
+			modify: func(entry *activitystream.Entry) {
+				entry.ID = "urn:uuid:60a76c80-d399-11d9-b93C-0003939e0af6"
+			},
+		},
+		{
+			name:   "author URI",
+			checks: DefaultChecks,
+			modify: func(entry *activitystream.Entry) {
+				entry.Author.URI = "https://example.org/users/bob"
+				entry.Author.Email = "bob@example.com"
+			},
+			err: CheckAuthorURI,
+		},
+		{
+			name:   "shared object",
+			checks: DefaultChecks,
+			modify: func(entry *activitystream.Entry) {
+				entry.Verb = activitystream.VerbShare
+				entry.Object = &activitystream.Entry{
+					ID:     "tag:example.org,2017:note-1",
+					Author: &activitystream.Person{URI: "acct:alice@example.org"},
+				}
+			},
+		},
+		{
+			name:   "object author",
+			checks: DefaultChecks | CheckObjectAuthor,
+			modify: func(entry *activitystream.Entry) {
+				entry.Verb = activitystream.VerbShare
+				entry.Object = &activitystream.Entry{
+					ID:     "tag:example.org,2017:note-1",
+					Author: &activitystream.Person{URI: "acct:alice@example.org"},
+				}
+			},
+			err: CheckObjectAuthor,
+		},
+		{
+			name:   "disabled",
+			checks: 0,
+			modify: func(entry *activitystream.Entry) {
+				entry.ID = "tag:example.org,2017:note-42"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		be := &testBackend{pub: &priv.PublicKey}
+		h := NewHandler(be)
+		h.Checks = test.checks
+
+		entry := testEntry()
+		test.modify(entry)
+
+		w := pushSalmon(t, h, entry, priv)
+		if test.err == 0 {
+			if w.Code != http.StatusAccepted {
+				t.Errorf("%v: ServeHTTP() = %v %v", test.name, w.Code, w.Body.String())
+			}
+			continue
+		}
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: ServeHTTP() = %v, want %v", test.name, w.Code, http.StatusBadRequest)
+		}
+		if len(be.notified) != 0 {
+			t.Errorf("%v: expected no notification, got %v", test.name, len(be.notified))
+		}
+
+		err := checkEntry(entry, testAccountURI, test.checks)
+		if err, ok := err.(*ConsistencyError); !ok || err.Check != test.err {
+			t.Errorf("%v: checkEntry() = %v, want a %v error", test.name, err, test.err)
+		}
+	}
+}
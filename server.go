@@ -1,6 +1,7 @@
 package ostatus
 
 import (
+	"log/slog"
 	"net/http"
 
 	"github.com/emersion/go-ostatus/pubsubhubbub"
@@ -21,25 +22,49 @@ type Handler struct {
 	http.Handler
 
 	Publisher *pubsubhubbub.Publisher
+	Salmon    *salmon.Handler
+	// Logger receives failures to serve feeds. Publisher and Salmon have their
+	// own loggers, use SetLogger to change all of them. If nil, slog.Default()
+	// is used.
+	Logger *slog.Logger
+}
+
+func (h *Handler) logger() *slog.Logger {
+	if h.Logger == nil {
+		return slog.Default()
+	}
+	return h.Logger
+}
+
+// SetLogger sets the logger of the handler, its publisher and its salmon
+// endpoint.
+func (h *Handler) SetLogger(logger *slog.Logger) {
+	h.Logger = logger
+	h.Publisher.Logger = logger
+	h.Salmon.Logger = logger
 }
 
 // NewHandler creates a new OStatus endpoint.
 func NewHandler(be Backend, hostmetaResource *xrd.Resource) *Handler {
 	mux := http.NewServeMux()
-	h := &Handler{Handler: mux}
+	h := &Handler{Handler: mux, Logger: slog.Default()}
 
 	p := pubsubhubbub.NewPublisher(be)
 	h.Publisher = p
 
+	s := salmon.NewHandler(be)
+	h.Salmon = s
+
 	mux.Handle(hostmeta.WellKnownPath, hostmeta.NewHandler(hostmetaResource))
 	mux.Handle(webfinger.WellKnownPath, webfinger.NewHandler(be))
 	mux.Handle(HubPath, p)
-	mux.Handle(SalmonPath, salmon.NewHandler(be))
+	mux.Handle(SalmonPath, s)
 
 	mux.HandleFunc("/", func(resp http.ResponseWriter, req *http.Request) {
 		topic := req.URL.String()
 		feed, err := be.Feed(topic)
 		if err != nil {
+			h.logger().Error("cannot get feed", "topic", topic, "err", err)
 			http.Error(resp, err.Error(), http.StatusInternalServerError)
 			return
 		}